@@ -1,15 +1,84 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
+	"supervisor/machine"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "sb",
-	Short: "Serverbench supervisor",
+	Use:           "sb",
+	Short:         "Serverbench supervisor",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+		return loadConfig(cmd)
+	},
+}
+
+// config
+
+var (
+	configPath     string
+	endpoint       string
+	caFile         string
+	pins           []string
+	insecure       bool
+	metricsAddress string
+	logLevel       string
+	logFormat      string
+	logOutput      string
+	traceEndpoint  string
+	traceFile      string
+)
+
+/*
+*
+the config file is read over the defaults, explicit flags win over both
+*/
+func loadConfig(cmd *cobra.Command) (err error) {
+	m.Config, err = machine.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("unable to read config %s: %w", configPath, err)
+	}
+	flags := cmd.Flags()
+	if flags.Changed("endpoint") {
+		m.Config.Endpoint = endpoint
+	}
+	if flags.Changed("ca") {
+		m.Config.CaFile = caFile
+	}
+	if flags.Changed("pin") {
+		m.Config.Pins = pins
+	}
+	if flags.Changed("insecure") {
+		m.Config.Insecure = insecure
+	}
+	if flags.Changed("metrics-address") {
+		m.Config.MetricsAddress = metricsAddress
+	}
+	if flags.Changed("trace-endpoint") {
+		m.Config.TraceEndpoint = traceEndpoint
+	}
+	if flags.Changed("trace-file") {
+		m.Config.TraceFile = traceFile
+	}
+	if flags.Changed("log-level") {
+		m.Config.LogLevel = logLevel
+	}
+	if flags.Changed("log-format") {
+		m.Config.LogFormat = logFormat
+	}
+	if flags.Changed("log-output") {
+		m.Config.LogOutput = logOutput
+	}
+	err = machine.ConfigureLogging(m.Config)
+	if err != nil {
+		return withCode(exitUsage, fmt.Errorf("unable to configure logging: %w", err))
+	}
+	return nil
 }
 
 // token
@@ -20,33 +89,42 @@ var token = &cobra.Command{
 }
 
 var tokenSet = &cobra.Command{
-	Use:   "set",
-	Short: "write token",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) < 1 {
-			// TODO bounds-of check
-			fmt.Println("please, provide a token")
-			panic(errors.New("missing token")) // lol
+	Use:   "set [token]",
+	Short: "write token, read from stdin when omitted or -",
+	Args:  rangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		value := "-"
+		if len(args) > 0 {
+			value = args[0]
 		}
-		err := m.UpdateToken(args[0])
+		if value == "-" {
+			// keeps the token out of shell history and the process list
+			input, err := io.ReadAll(io.LimitReader(os.Stdin, 64*1024))
+			if err != nil {
+				return fmt.Errorf("unable to read token from stdin: %w", err)
+			}
+			value = string(input)
+		}
+		err = m.UpdateToken(value)
 		if err != nil {
-			fmt.Println("unable to update token")
-			panic(err)
+			return fmt.Errorf("unable to update token: %w", err)
 		}
-		fmt.Println(args[0])
+		fmt.Println("token updated")
+		return nil
 	},
 }
 
 var tokenRead = &cobra.Command{
 	Use:   "get",
 	Short: "read token",
-	Run: func(cmd *cobra.Command, args []string) {
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		token, err := m.GetToken()
 		if err != nil {
-			fmt.Println("unable to read token")
-			panic(err)
+			return fmt.Errorf("unable to read token: %w", err)
 		}
 		fmt.Println(*token)
+		return nil
 	},
 }
 
@@ -55,16 +133,30 @@ var tokenRead = &cobra.Command{
 var start = &cobra.Command{
 	Use:   "start",
 	Short: "starts serverbench supervisor",
-	Run: func(cmd *cobra.Command, args []string) {
-		err := m.Init(0)
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = m.Init()
 		if err != nil {
-			fmt.Println("unable to init")
-			panic(err)
+			return fmt.Errorf("unable to init: %w", err)
 		}
+		return nil
 	},
 }
 
 func init() {
+	// config
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", machine.DefaultConfigPath, "config file")
+	rootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", machine.DefaultEndpoint, "backend websocket endpoint")
+	rootCmd.PersistentFlags().StringVar(&caFile, "ca", "", "pem bundle used instead of the system CAs")
+	rootCmd.PersistentFlags().StringSliceVar(&pins, "pin", nil, "base64 sha256 of an accepted backend public key (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&insecure, "insecure", false, "allow plaintext ws and skip certificate verification (local development)")
+	// logging
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level (trace, debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format (text, json)")
+	rootCmd.PersistentFlags().StringVar(&logOutput, "log-output", "stderr", "log output (stdout, stderr, syslog or a file path)")
+	start.Flags().StringVar(&traceEndpoint, "trace-endpoint", "", "export traces over otlp/http to this endpoint, e.g. http://127.0.0.1:4318")
+	start.Flags().StringVar(&traceFile, "trace-file", "", "append traces as json to this file")
+	start.Flags().StringVar(&metricsAddress, "metrics-address", "", "serve prometheus metrics on this address, e.g. 127.0.0.1:9464")
 	// token
 	token.AddCommand(tokenRead)
 	token.AddCommand(tokenSet)
@@ -75,7 +167,9 @@ func init() {
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		if err.Error() != "" {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	dContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"supervisor/machine"
+	"supervisor/machine/container"
+	"supervisor/machine/container/listener"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"text/tabwriter"
+)
+
+// container
+
+type containerRow struct {
+	Id        string          `json:"id"`
+	Status    string          `json:"status"`
+	Readiness string          `json:"readiness,omitempty"`
+	Handler   *listener.State `json:"handler,omitempty"`
+}
+
+var (
+	follow  bool
+	tail    string
+	addKey  string
+	dropKey string
+)
+
+var containerCmd = &cobra.Command{
+	Use:   "container",
+	Short: "manage hosted containers",
+}
+
+func admin() *machine.AdminClient {
+	return machine.NewAdminClient(m.Config.AdminSocket)
+}
+
+func dockerClient() (cli *client.Client, err error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
+
+/*
+*
+the hosted container as the supervisor loads it, for acting on docker directly
+*/
+func localContainer(id string) (cli *client.Client, local *container.Container, err error) {
+	cli, err = dockerClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err = container.Lookup(cli, id)
+	return cli, local, err
+}
+
+func dockerStatus(cli *client.Client, id string) string {
+	inspect, err := cli.ContainerInspect(context.Background(), "sb-"+id)
+	if err != nil || inspect.State == nil {
+		return "unknown"
+	}
+	return inspect.State.Status
+}
+
+/*
+*
+runs a container command on the supervisor, or directly when it isn't running
+and a fallback exists
+*/
+func containerCommand(command string, id string, data interface{}, fallback func() (*machine.AdminResponse, error)) (response *machine.AdminResponse, err error) {
+	response, err = admin().Command("container", command, &id, data)
+	if errors.Is(err, machine.AdminUnavailableErr) && fallback != nil {
+		fmt.Fprintln(os.Stderr, "supervisor unreachable, acting on docker directly")
+		return fallback()
+	}
+	return response, err
+}
+
+var containerList = &cobra.Command{
+	Use:   "list",
+	Short: "list hosted containers",
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return err
+		}
+		rows := make([]containerRow, 0)
+		states, err := admin().Containers()
+		if err == nil {
+			for _, state := range states {
+				row := containerRow{Id: state.Id, Status: dockerStatus(cli, state.Id)}
+				detailed, err := admin().Container(state.Id)
+				if err == nil && detailed.Handler != nil {
+					row.Readiness = string(detailed.Handler.Readiness)
+				}
+				rows = append(rows, row)
+			}
+		} else if errors.Is(err, machine.AdminUnavailableErr) {
+			fmt.Fprintln(os.Stderr, "supervisor unreachable, listing docker containers")
+			listed, err := cli.ContainerList(context.Background(), dContainer.ListOptions{All: true})
+			if err != nil {
+				return err
+			}
+			for _, listedContainer := range listed {
+				for _, name := range listedContainer.Names {
+					hosted, ok := container.FromDockerName(name)
+					if ok {
+						rows = append(rows, containerRow{Id: hosted.Id, Status: listedContainer.State})
+					}
+				}
+			}
+		} else {
+			return err
+		}
+		return render(rows, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "ID\tSTATUS\tREADINESS")
+			for _, row := range rows {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", row.Id, row.Status, row.Readiness)
+			}
+		})
+	},
+}
+
+var containerInspect = &cobra.Command{
+	Use:   "inspect <id>",
+	Short: "show a container and its supervisor state",
+	Args:  exactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return err
+		}
+		row := containerRow{Id: args[0], Status: dockerStatus(cli, args[0])}
+		state, err := admin().Container(args[0])
+		if err == nil {
+			row.Handler = state.Handler
+			if state.Handler != nil {
+				row.Readiness = string(state.Handler.Readiness)
+			}
+		} else if !errors.Is(err, machine.AdminUnavailableErr) {
+			return err
+		} else if row.Status == "unknown" {
+			return withCode(exitNotFound, errors.New("container not found"))
+		}
+		return render(row, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "id\t%s\n", row.Id)
+			fmt.Fprintf(w, "status\t%s\n", row.Status)
+			fmt.Fprintf(w, "readiness\t%s\n", row.Readiness)
+			if row.Handler == nil {
+				return
+			}
+			for level, subscribers := range row.Handler.Subscribers {
+				fmt.Fprintf(w, "subscribers (%s)\t%s\n", level, strings.Join(subscribers, ", "))
+			}
+			for name, streamState := range row.Handler.Streams {
+				fmt.Fprintf(w, "stream (%s)\topen=%t last read %s\n", name, streamState.Open, streamState.LastRead.Format("2006-01-02 15:04:05"))
+			}
+			for _, watcher := range row.Handler.Watchers {
+				fmt.Fprintf(w, "watcher (%s)\t%s\n", watcher.Id, watcher.Pattern)
+			}
+			for _, progress := range row.Handler.Activities {
+				fmt.Fprintf(w, "activity (%s)\t%s %d%%\n", progress.Id, progress.Description, progress.Progress)
+			}
+		})
+	},
+}
+
+/*
+*
+a power action sent to the supervisor as command, or run through the same container
+method on docker directly when the supervisor is down
+*/
+func powerCommand(use string, command string, short string, direct func(local *container.Container, cli *client.Client) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <id>",
+		Short: short,
+		Args:  exactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			_, err = containerCommand(command, args[0], nil, func() (*machine.AdminResponse, error) {
+				cli, local, err := localContainer(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return nil, direct(local, cli)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(use + " " + args[0])
+			return nil
+		},
+	}
+}
+
+// starts the existing container, recreating it is left to the backend's host and start
+var containerStart = powerCommand("start", "power_on", "start a container", func(local *container.Container, cli *client.Client) error {
+	return local.PowerOn(cli)
+})
+
+var containerStop = powerCommand("stop", "stop", "stop a container", func(local *container.Container, cli *client.Client) error {
+	return local.Stop(cli)
+})
+
+var containerRestart = powerCommand("restart", "restart", "restart a container", func(local *container.Container, cli *client.Client) error {
+	return local.Restart(cli)
+})
+
+var containerLogs = &cobra.Command{
+	Use:   "logs <id>",
+	Short: "print container logs",
+	Args:  exactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return err
+		}
+		name := "sb-" + args[0]
+		inspect, err := cli.ContainerInspect(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		logs, err := cli.ContainerLogs(cmd.Context(), name, dContainer.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     follow,
+			Tail:       tail,
+		})
+		if err != nil {
+			return err
+		}
+		defer logs.Close()
+		if inspect.Config != nil && inspect.Config.Tty {
+			_, err = io.Copy(os.Stdout, logs)
+		} else {
+			_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, logs)
+		}
+		return err
+	},
+}
+
+var containerExec = &cobra.Command{
+	Use:   "exec <id> -- <command> [args...]",
+	Short: "run a command inside a container",
+	Args:  minimumArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		created, err := cli.ContainerExecCreate(ctx, "sb-"+args[0], types.ExecConfig{
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd:          args[1:],
+		})
+		if err != nil {
+			return err
+		}
+		attached, err := cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+		if err != nil {
+			return err
+		}
+		defer attached.Close()
+		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attached.Reader)
+		if err != nil {
+			return err
+		}
+		inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+		if err != nil {
+			return err
+		}
+		if inspect.ExitCode != 0 {
+			return withCode(inspect.ExitCode, nil)
+		}
+		return nil
+	},
+}
+
+var containerKeys = &cobra.Command{
+	Use:   "keys <id>",
+	Short: "list, authorize or deauthorize sftp keys",
+	Args:  exactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if addKey != "" || dropKey != "" {
+			command, key := "authorize_key", addKey
+			if dropKey != "" {
+				command, key = "deauthorize_key", dropKey
+			}
+			_, err = containerCommand(command, args[0], in.KeyRequest{Key: key}, func() (*machine.AdminResponse, error) {
+				_, local, err := localContainer(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if command == "authorize_key" {
+					return nil, local.AddAuthorizedKey(key)
+				}
+				return nil, local.RemoveAuthorizedKey(key)
+			})
+			return err
+		}
+		keys := make([]string, 0)
+		response, err := containerCommand("list_authorized_keys", args[0], nil, func() (*machine.AdminResponse, error) {
+			_, local, err := localContainer(args[0])
+			if err != nil {
+				return nil, err
+			}
+			keys, err = local.ListAuthorizedKeys()
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if response != nil {
+			listed := out.KeyListResponse{}
+			err = json.Unmarshal(response.Data, &listed)
+			if err != nil {
+				return err
+			}
+			keys = listed.Keys
+		}
+		return render(keys, func(w *tabwriter.Writer) {
+			for _, key := range keys {
+				fmt.Fprintln(w, key)
+			}
+		})
+	},
+}
+
+var containerPassword = &cobra.Command{
+	Use:   "password <id>",
+	Short: "reset the sftp password",
+	Args:  exactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		password := out.PasswordResponse{}
+		response, err := containerCommand("password", args[0], nil, func() (*machine.AdminResponse, error) {
+			_, local, err := localContainer(args[0])
+			if err != nil {
+				return nil, err
+			}
+			reset, err := local.ResetPassword()
+			if err == nil {
+				password.Password = *reset
+			}
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if response != nil {
+			err = json.Unmarshal(response.Data, &password)
+			if err != nil {
+				return err
+			}
+		}
+		return render(password, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, password.Password)
+		})
+	},
+}
+
+func init() {
+	containerLogs.Flags().BoolVarP(&follow, "follow", "f", false, "follow log output")
+	containerLogs.Flags().StringVar(&tail, "tail", "all", "number of lines to show from the end")
+	containerKeys.Flags().StringVar(&addKey, "add", "", "authorize a public key")
+	containerKeys.Flags().StringVar(&dropKey, "remove", "", "deauthorize a public key")
+	containerCmd.PersistentFlags().StringVarP(&output, "output", "o", "table", "output format (table, json)")
+	containerCmd.AddCommand(containerList)
+	containerCmd.AddCommand(containerInspect)
+	containerCmd.AddCommand(containerStart)
+	containerCmd.AddCommand(containerStop)
+	containerCmd.AddCommand(containerRestart)
+	containerCmd.AddCommand(containerLogs)
+	containerCmd.AddCommand(containerExec)
+	containerCmd.AddCommand(containerKeys)
+	containerCmd.AddCommand(containerPassword)
+	rootCmd.AddCommand(containerCmd)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"strconv"
+	"text/tabwriter"
+)
+
+// doctor
+
+var doctor = &cobra.Command{
+	Use:   "doctor",
+	Short: "check everything hosting depends on",
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		checks := m.Diagnose()
+		failed := 0
+		for _, check := range checks {
+			if !check.Ok {
+				failed++
+			}
+		}
+		err = render(checks, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+			for _, check := range checks {
+				result := "ok"
+				if !check.Ok {
+					result = "FAIL"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, result, check.Detail)
+			}
+			for _, check := range checks {
+				if !check.Ok && check.Hint != "" {
+					fmt.Fprintf(w, "\n%s: %s", check.Name, check.Hint)
+				}
+			}
+			if failed > 0 {
+				fmt.Fprintln(w)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return withCode(exitFailure, errors.New(strconv.Itoa(failed)+" check(s) failed"))
+		}
+		return nil
+	},
+}
+
+func init() {
+	doctor.Flags().StringVarP(&output, "output", "o", "table", "output format (table, json)")
+	rootCmd.AddCommand(doctor)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/spf13/cobra"
+	"net/http"
+	"supervisor/machine"
+)
+
+// process exit codes
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitUnavailable = 3
+	exitNotFound    = 4
+)
+
+/*
+*
+an error carrying the exit code the process should end with
+*/
+type exitErr struct {
+	code int
+	err  error
+}
+
+func (e *exitErr) Error() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+func (e *exitErr) Unwrap() error {
+	return e.err
+}
+
+func withCode(code int, err error) error {
+	return &exitErr{code: code, err: err}
+}
+
+func exitCode(err error) int {
+	var coded *exitErr
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var adminErr *machine.AdminErr
+	switch {
+	case errors.Is(err, machine.AdminUnavailableErr), client.IsErrConnectionFailed(err):
+		return exitUnavailable
+	case errors.As(err, &adminErr) && adminErr.Status == http.StatusNotFound,
+		errors.As(err, &adminErr) && adminErr.Failure.Code == "container_not_found",
+		errdefs.IsNotFound(err):
+		return exitNotFound
+	}
+	return exitFailure
+}
+
+/*
+*
+positional argument validation reported as a usage error
+*/
+func exactArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(n)(cmd, args)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+		return nil
+	}
+}
+
+func minimumArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := cobra.MinimumNArgs(n)(cmd, args)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+		return nil
+	}
+}
+
+func rangeArgs(min int, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := cobra.RangeArgs(min, max)(cmd, args)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+		return nil
+	}
+}
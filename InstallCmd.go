@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+	"supervisor/machine"
+)
+
+// install
+
+var dryRun bool
+
+/*
+*
+applies the steps in order and stops at the first failure, dry runs only describe them
+*/
+func apply(steps []machine.Step) (err error) {
+	if len(steps) <= 0 {
+		fmt.Println("nothing to change")
+		return nil
+	}
+	for _, step := range steps {
+		if dryRun {
+			fmt.Println("would " + step.Description)
+			if step.Content != "" {
+				fmt.Println("  " + strings.ReplaceAll(strings.TrimRight(step.Content, "\n"), "\n", "\n  "))
+			}
+			continue
+		}
+		fmt.Println(step.Description)
+		err = step.Apply()
+		if err != nil {
+			return fmt.Errorf("unable to %s: %w", step.Description, err)
+		}
+	}
+	return nil
+}
+
+func requireRoot() (err error) {
+	if !dryRun && os.Geteuid() != 0 {
+		return withCode(exitUsage, errors.New("must be run as root (or with --dry-run)"))
+	}
+	return nil
+}
+
+var install = &cobra.Command{
+	Use:   "install",
+	Short: "install the supervisor service and host prerequisites",
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = requireRoot()
+		if err != nil {
+			return err
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		executable, err = filepath.EvalSymlinks(executable)
+		if err != nil {
+			return err
+		}
+		configPath, err := filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		steps, err := m.InstallPlan(executable, configPath)
+		if err != nil {
+			return err
+		}
+		err = apply(steps)
+		if err != nil || dryRun {
+			return err
+		}
+		fmt.Println()
+		return doctor.RunE(cmd, args)
+	},
+}
+
+var uninstall = &cobra.Command{
+	Use:   "uninstall",
+	Short: "stop and remove the supervisor service, hosted containers and their data are kept",
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = requireRoot()
+		if err != nil {
+			return err
+		}
+		return apply(machine.UninstallPlan())
+	},
+}
+
+func init() {
+	install.Flags().BoolVar(&dryRun, "dry-run", false, "print every change without applying it")
+	uninstall.Flags().BoolVar(&dryRun, "dry-run", false, "print every change without applying it")
+	rootCmd.AddCommand(install)
+	rootCmd.AddCommand(uninstall)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"supervisor/machine"
+	"syscall"
+)
+
+// login
+
+var login = &cobra.Command{
+	Use:   "login",
+	Short: "enroll this machine, an admin approves it with the shown code",
+	Args:  exactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		enrollments, err := machine.NewEnrollmentClient(m.Config)
+		if err != nil {
+			return err
+		}
+		enrollment, err := enrollments.Request(ctx, hostname)
+		if err != nil {
+			return withCode(exitUnavailable, fmt.Errorf("unable to start the enrollment: %w", err))
+		}
+		fmt.Println("enrollment code: " + enrollment.Code)
+		if enrollment.VerificationUrl != "" {
+			fmt.Println("approve it at " + enrollment.VerificationUrl)
+		}
+		if !enrollment.ExpiresAt.IsZero() {
+			fmt.Println("the code expires at " + enrollment.ExpiresAt.Local().Format("15:04:05"))
+		}
+		fmt.Println("waiting for approval...")
+		token, err := enrollments.Await(ctx, enrollment)
+		if err != nil {
+			return err
+		}
+		err = m.UpdateToken(token)
+		if err != nil {
+			return fmt.Errorf("unable to store the token: %w", err)
+		}
+		fmt.Println("machine enrolled, restart the supervisor to connect with the new token")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(login)
+}
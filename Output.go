@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+var output string
+
+/*
+*
+prints the value as indented json, or through the table writer in table mode
+*/
+func render(value interface{}, table func(w *tabwriter.Writer)) (err error) {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+	return withCode(exitUsage, fmt.Errorf("unknown output %s (table, json)", output))
+}
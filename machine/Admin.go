@@ -0,0 +1,200 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/thanhpk/randstr"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"syscall"
+)
+
+const DefaultAdminSocket = "/run/serverbench/supervisor.sock"
+
+var (
+	InsecureAdminDirErr = errors.New("the admin socket must live in a directory only the supervisor user can access (0700, not a symlink)")
+)
+
+/*
+*
+local http/json api on a root-only unix socket. it runs the same commands as
+the backend connection (through the same per-container queues) and exposes the
+supervisor internals
+*/
+func (m *Machine) serveAdmin() (err error) {
+	socket := m.Config.AdminSocket
+	if socket == "" {
+		socket = DefaultAdminSocket
+	}
+	err = privateDirectory(filepath.Dir(socket), socket == DefaultAdminSocket)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(socket)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", m.adminStatus)
+	mux.HandleFunc("GET /v1/containers", m.adminContainers)
+	mux.HandleFunc("GET /v1/containers/{id}", m.adminContainer)
+	mux.HandleFunc("POST /v1/command", m.adminCommand)
+	m.logger().Info("admin api listening on " + socket)
+	go func() {
+		err := http.Serve(listener, mux)
+		m.logger().Error("admin api stopped: ", err)
+	}()
+	return nil
+}
+
+/*
+*
+makes sure nobody else can reach the socket while it is being set up: the directory is
+created 0700 or has to already be a private directory of the current user. our own
+default directory is tightened when an older install left it open
+*/
+func privateDirectory(directory string, owned bool) (err error) {
+	err = os.MkdirAll(directory, 0700)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(directory)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() {
+		return InsecureAdminDirErr
+	}
+	if info.Mode().Perm()&0077 == 0 {
+		return nil
+	}
+	if !owned {
+		return InsecureAdminDirErr
+	}
+	return os.Chmod(directory, 0700)
+}
+
+func (m *Machine) adminStatus(w http.ResponseWriter, r *http.Request) {
+	reply := m.status("local")
+	reply.Data = struct {
+		out.StatusResponse
+		Commands map[string]int `json:"commands"`
+	}{
+		StatusResponse: reply.Data.(out.StatusResponse),
+		Commands:       m.dispatcher.Depth(),
+	}
+	writeAdmin(w, http.StatusOK, reply)
+}
+
+func (m *Machine) adminContainers(w http.ResponseWriter, r *http.Request) {
+	states := make([]out.ContainerState, 0)
+	for _, hosted := range m.Containers.Snapshot() {
+		states = append(states, out.ContainerState{Id: hosted.Id})
+	}
+	writeAdmin(w, http.StatusOK, out.Response{
+		Rid:  "local",
+		Type: "containers",
+		Data: states,
+	})
+}
+
+func (m *Machine) adminContainer(w http.ResponseWriter, r *http.Request) {
+	hosted, ok := m.Containers.Get(r.PathValue("id"))
+	if !ok {
+		writeAdmin(w, http.StatusNotFound, out.Response{
+			Rid:     "local",
+			Type:    "container",
+			Error:   true,
+			Failure: failure(ContainerNotFoundErr),
+		})
+		return
+	}
+	state := out.ContainerState{Id: hosted.Id}
+	if hosted.Handler != nil {
+		handlerState := hosted.Handler.State()
+		state.Handler = &handlerState
+	}
+	writeAdmin(w, http.StatusOK, out.Response{
+		Rid:  "local",
+		Type: "container",
+		Data: state,
+	})
+}
+
+/*
+*
+runs a v2 envelope and waits for its completion, the reply (if any) is returned
+as is and failures come back as a completion with its error code
+*/
+func (m *Machine) adminCommand(w http.ResponseWriter, r *http.Request) {
+	envelope := in.Envelope{}
+	err := json.NewDecoder(r.Body).Decode(&envelope)
+	if err != nil {
+		writeAdmin(w, http.StatusBadRequest, out.Response{
+			Rid:     "local",
+			Type:    "complete",
+			Error:   true,
+			Failure: failure(err),
+		})
+		return
+	}
+	if envelope.Rid == "" {
+		envelope.Rid = "local-" + randstr.Hex(8)
+	}
+	message := envelope.Message()
+	m.logger().Info("local request " + message.Rid + ": " + message.Realm + "/" + message.Command)
+	reply, err := m.run(message)
+	if err != nil {
+		writeAdmin(w, http.StatusUnprocessableEntity, out.Response{
+			Rid:     message.Rid,
+			Type:    "complete",
+			Error:   true,
+			Failure: failure(err),
+		})
+		return
+	}
+	if reply == nil {
+		reply = &out.Response{
+			Rid:  message.Rid,
+			Type: "complete",
+		}
+	}
+	writeAdmin(w, http.StatusOK, reply)
+}
+
+/*
+*
+runs a message on the dispatcher and waits for it to finish
+*/
+func (m *Machine) run(message in.Message) (reply *out.Response, err error) {
+	finished := make(chan struct{})
+	_, err = m.dispatcher.Submit(queueKey(message), func() {
+		defer close(finished)
+		reply, err = m.handle(message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	<-finished
+	return reply, err
+}
+
+func writeAdmin(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,146 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"time"
+)
+
+var (
+	AdminUnavailableErr = errors.New("the supervisor is not running (admin socket unreachable)")
+)
+
+/*
+*
+failure reported by the admin api
+*/
+type AdminErr struct {
+	Status  int
+	Failure out.ErrorResponse
+}
+
+func (e *AdminErr) Error() string {
+	return e.Failure.Message + " (" + e.Failure.Code + ")"
+}
+
+/*
+*
+admin api response, with the data left undecoded
+*/
+type AdminResponse struct {
+	Rid     string             `json:"rid"`
+	Type    string             `json:"type"`
+	Data    json.RawMessage    `json:"data"`
+	Error   bool               `json:"error"`
+	Failure *out.ErrorResponse `json:"failure,omitempty"`
+}
+
+type AdminClient struct {
+	Socket string
+	http   *http.Client
+}
+
+func NewAdminClient(socket string) *AdminClient {
+	if socket == "" {
+		socket = DefaultAdminSocket
+	}
+	return &AdminClient{
+		Socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					dialer := net.Dialer{Timeout: 2 * time.Second}
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *AdminClient) Available() bool {
+	_, err := c.get("/v1/status")
+	return err == nil
+}
+
+func (c *AdminClient) Status() (response *AdminResponse, err error) {
+	return c.get("/v1/status")
+}
+
+func (c *AdminClient) Containers() (containers []out.ContainerState, err error) {
+	response, err := c.get("/v1/containers")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(response.Data, &containers)
+	return containers, err
+}
+
+func (c *AdminClient) Container(id string) (state *out.ContainerState, err error) {
+	response, err := c.get("/v1/containers/" + id)
+	if err != nil {
+		return nil, err
+	}
+	state = &out.ContainerState{}
+	err = json.Unmarshal(response.Data, state)
+	return state, err
+}
+
+/*
+*
+runs a command on the supervisor and waits for it to complete
+*/
+func (c *AdminClient) Command(realm string, command string, target *string, data interface{}) (response *AdminResponse, err error) {
+	envelope := in.Envelope{
+		Version:   ProtocolV2,
+		Realm:     realm,
+		Command:   command,
+		Target:    target,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if data != nil {
+		envelope.Data, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	httpResponse, err := c.http.Post("http://supervisor/v1/command", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, AdminUnavailableErr
+	}
+	return c.decode(httpResponse)
+}
+
+func (c *AdminClient) get(path string) (response *AdminResponse, err error) {
+	httpResponse, err := c.http.Get("http://supervisor" + path)
+	if err != nil {
+		return nil, AdminUnavailableErr
+	}
+	return c.decode(httpResponse)
+}
+
+func (c *AdminClient) decode(httpResponse *http.Response) (response *AdminResponse, err error) {
+	defer httpResponse.Body.Close()
+	response = &AdminResponse{}
+	err = json.NewDecoder(httpResponse.Body).Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error {
+		adminErr := &AdminErr{Status: httpResponse.StatusCode}
+		if response.Failure != nil {
+			adminErr.Failure = *response.Failure
+		}
+		return nil, adminErr
+	}
+	return response, nil
+}
@@ -0,0 +1,161 @@
+package machine
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/thanhpk/randstr"
+	"strconv"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"sync"
+	"time"
+)
+
+const (
+	nonceHeader = "X-Serverbench-Nonce"
+	// signed messages older/newer than this are rejected
+	replayWindow = 30 * time.Second
+)
+
+var (
+	ChallengeErr        = errors.New("backend did not complete the challenge")
+	InvalidSignatureErr = errors.New("invalid message signature")
+	ReplayedMessageErr  = errors.New("message outside the replay window")
+)
+
+/*
+*
+per connection authentication state. both sides prove knowledge of the token
+over the other side's nonce, and when signing is enabled every incoming message
+carries an hmac over its fields keyed by a secret derived from both nonces
+*/
+type Auth struct {
+	Signed bool
+	// where the backend left off, from the challenge
+	Replay *in.ReplayRequest
+	key    []byte
+	seen   map[string]time.Time
+	mutex  sync.Mutex
+}
+
+func newNonce() string {
+	return randstr.Hex(16)
+}
+
+func prove(secret string, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+*
+waits for the backend challenge, checks the backend proof and answers with ours
+*/
+func (m *Machine) authenticate(conn *websocket.Conn, token string, nonce string) (auth *Auth, err error) {
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	messageType, raw, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	// the version isn't settled yet, either message shape is accepted
+	lenient := Protocol{Version: ProtocolV2}
+	message, err := lenient.decode(messageType, raw)
+	if err != nil {
+		return nil, err
+	}
+	if message.Realm != "machine" || message.Command != "challenge" || message.Data == nil {
+		return nil, ChallengeErr
+	}
+	challenge := in.ChallengeRequest{}
+	err = json.Unmarshal([]byte(*message.Data), &challenge)
+	if err != nil {
+		return nil, err
+	}
+	if len(challenge.Nonce) <= 0 || !hmac.Equal([]byte(challenge.Proof), []byte(prove(token, nonce))) {
+		return nil, ChallengeErr
+	}
+	m.negotiated(negotiate(challenge))
+	err = m.write(out.Response{
+		Rid:  message.Rid,
+		Type: "challenge",
+		Data: out.ChallengeResponse{
+			Proof: prove(token, challenge.Nonce),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	auth = &Auth{
+		Signed: challenge.Signed || m.Config.Signed,
+		Replay: challenge.Replay,
+		key:    []byte(prove(token, "signing|"+nonce+"|"+challenge.Nonce)),
+		seen:   make(map[string]time.Time),
+	}
+	return auth, nil
+}
+
+/*
+*
+checks the message signature and rejects anything outside the replay window or
+already seen within it
+*/
+func (a *Auth) Verify(message in.Message) (err error) {
+	if !a.Signed {
+		return nil
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(canonical(message))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(message.Signature), []byte(expected)) {
+		return InvalidSignatureErr
+	}
+	now := time.Now()
+	sent := time.UnixMilli(message.Timestamp)
+	if sent.Before(now.Add(-replayWindow)) || sent.After(now.Add(replayWindow)) {
+		return ReplayedMessageErr
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for rid, seenAt := range a.seen {
+		if seenAt.Before(now.Add(-2 * replayWindow)) {
+			delete(a.seen, rid)
+		}
+	}
+	if _, ok := a.seen[message.Rid]; ok {
+		return ReplayedMessageErr
+	}
+	a.seen[message.Rid] = now
+	return nil
+}
+
+/*
+*
+what the signature covers: rid, realm, command, target, data and timestamp joined by
+newlines. data is taken as it was on the wire, the string itself in v1 messages, the
+raw json value in v2 text frames and the packed value in msgpack frames, so no key
+order or number formatting has to be agreed on
+*/
+func canonical(message in.Message) []byte {
+	target := ""
+	if message.Target != nil {
+		target = *message.Target
+	}
+	data := message.SignedData
+	if data == nil && message.Data != nil {
+		data = []byte(*message.Data)
+	}
+	return bytes.Join([][]byte{
+		[]byte(message.Rid),
+		[]byte(message.Realm),
+		[]byte(message.Command),
+		[]byte(target),
+		data,
+		[]byte(strconv.FormatInt(message.Timestamp, 10)),
+	}, newline)
+}
@@ -0,0 +1,130 @@
+package machine
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var signingKey = []byte("test signing key")
+
+/*
+*
+signs the way the backend does, over the data bytes it puts on the wire
+*/
+func backendSignature(rid string, target string, data []byte, timestamp int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(rid + "\ncontainer\nstart\n" + target + "\n"))
+	mac.Write(data)
+	mac.Write([]byte("\n" + strconv.FormatInt(timestamp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+*
+data with keys out of order and spacing no encoder would produce, a signature over
+re-encoded data can't match it
+*/
+func packedData(t *testing.T) []byte {
+	buffer := &bytes.Buffer{}
+	encoder := msgpack.NewEncoder(buffer)
+	for _, err := range []error{
+		encoder.EncodeMapLen(2),
+		encoder.EncodeString("zone"),
+		encoder.EncodeInt(7),
+		encoder.EncodeString("action"),
+		encoder.EncodeString("boot"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buffer.Bytes()
+}
+
+func TestSignatureCoversWireData(t *testing.T) {
+	target := "c1"
+	jsonData := []byte("{ \"zone\": 7,\n  \"action\": \"boot\" }")
+	cases := []struct {
+		name  string
+		frame func(rid string, timestamp int64, tamper bool) (int, []byte)
+	}{
+		{"v1 json", func(rid string, timestamp int64, tamper bool) (int, []byte) {
+			data := string(jsonData)
+			signature := backendSignature(rid, target, []byte(data), timestamp)
+			if tamper {
+				data = `{"zone":8,"action":"boot"}`
+			}
+			frame, _ := json.Marshal(map[string]interface{}{
+				"rid": rid, "realm": "container", "command": "start", "target": target,
+				"data": data, "timestamp": timestamp, "signature": signature,
+			})
+			return websocket.TextMessage, frame
+		}},
+		{"v2 json", func(rid string, timestamp int64, tamper bool) (int, []byte) {
+			signature := backendSignature(rid, target, jsonData, timestamp)
+			data := jsonData
+			if tamper {
+				data = []byte(`{"action":"boot","zone":7}`)
+			}
+			frame := []byte(`{"v":2,"rid":"` + rid + `","realm":"container","command":"start","target":"` + target +
+				`","data":` + string(data) + `,"timestamp":` + strconv.FormatInt(timestamp, 10) +
+				`,"signature":"` + signature + `"}`)
+			return websocket.TextMessage, frame
+		}},
+		{"v2 msgpack", func(rid string, timestamp int64, tamper bool) (int, []byte) {
+			data := packedData(t)
+			signature := backendSignature(rid, target, data, timestamp)
+			if tamper {
+				data, _ = msgpack.Marshal(map[string]interface{}{"zone": 8, "action": "boot"})
+			}
+			frame, err := msgpack.Marshal(packedEnvelope{
+				Version: ProtocolV2, Rid: rid, Realm: "container", Command: "start", Target: &target,
+				Data: data, Timestamp: timestamp, Signature: signature,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return websocket.BinaryMessage, frame
+		}},
+	}
+	protocol := Protocol{Version: ProtocolV2, Encoding: MsgpackEncoding}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			auth := &Auth{Signed: true, key: signingKey, seen: make(map[string]time.Time)}
+			timestamp := time.Now().UnixMilli()
+			message, err := protocol.decode(c.frame("r1", timestamp, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = auth.Verify(message)
+			if err != nil {
+				t.Fatalf("valid signature rejected: %v", err)
+			}
+			decoded := struct {
+				Zone   int    `json:"zone"`
+				Action string `json:"action"`
+			}{}
+			err = message.Decode(&decoded)
+			if err != nil || decoded.Zone != 7 || decoded.Action != "boot" {
+				t.Fatalf("data didn't reach the handler intact: %+v, %v", decoded, err)
+			}
+			tampered, err := protocol.decode(c.frame("r2", timestamp, true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = auth.Verify(tampered)
+			if !errors.Is(err, InvalidSignatureErr) {
+				t.Fatalf("tampered data accepted: %v", err)
+			}
+		})
+	}
+}
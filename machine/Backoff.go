@@ -0,0 +1,29 @@
+package machine
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+*
+exponential backoff with full jitter
+*/
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+func (b *Backoff) Next() (wait time.Duration) {
+	ceiling := b.Max
+	if b.attempt < 32 && b.Min<<b.attempt < b.Max {
+		ceiling = b.Min << b.attempt
+	}
+	b.attempt++
+	return b.Min + time.Duration(rand.Int63n(int64(ceiling-b.Min)+1))
+}
+
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,97 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultConfigPath = "/etc/serverbench/supervisor.json"
+	DefaultEndpoint   = "wss://hansel.serverbench.io/machine"
+)
+
+type Config struct {
+	// backend websocket endpoint, a bare host is assumed to be wss
+	Endpoint string `json:"endpoint"`
+	// pem bundle replacing the system CAs
+	CaFile string `json:"caFile,omitempty"`
+	// base64 encoded sha256 hashes of accepted subject public key infos
+	Pins []string `json:"pins,omitempty"`
+	// require every backend message to be signed, even if the backend doesn't ask for it
+	Signed bool `json:"signed,omitempty"`
+	// unix socket of the local admin api
+	AdminSocket string `json:"adminSocket,omitempty"`
+	// local address serving prometheus metrics on /metrics, disabled when empty
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+	// otlp/http endpoint receiving traces, e.g. http://127.0.0.1:4318
+	TraceEndpoint string `json:"traceEndpoint,omitempty"`
+	// file traces are appended to as json, for offline debugging
+	TraceFile string `json:"traceFile,omitempty"`
+	// disables per message deflate on the backend connection
+	DisableCompression bool `json:"disableCompression,omitempty"`
+	// panic, fatal, error, warn, info, debug or trace
+	LogLevel string `json:"logLevel,omitempty"`
+	// text or json
+	LogFormat string `json:"logFormat,omitempty"`
+	// stdout, stderr (default), syslog or a file path
+	LogOutput string `json:"logOutput,omitempty"`
+	// operator defined placement hints reported with the inventory, region or tier for example
+	Labels map[string]string `json:"labels,omitempty"`
+	// stores the token encrypted with a key derived from the machine id
+	SealToken bool `json:"sealToken,omitempty"`
+	// allows plaintext ws and skips certificate verification, local development only
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Endpoint: DefaultEndpoint,
+	}
+}
+
+/*
+*
+reads the config file over the defaults, a missing file just keeps the defaults
+*/
+func LoadConfig(path string) (config Config, err error) {
+	config = DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+func (c *Config) EndpointUrl() (endpoint *url.URL, err error) {
+	raw := c.Endpoint
+	if raw == "" {
+		raw = DefaultEndpoint
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "wss://" + raw
+	}
+	endpoint, err = url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/machine"
+	}
+	switch endpoint.Scheme {
+	case "wss":
+	case "ws":
+		if !c.Insecure {
+			return nil, errors.New("plaintext ws endpoints require --insecure")
+		}
+	default:
+		return nil, errors.New("unsupported endpoint scheme " + endpoint.Scheme)
+	}
+	return endpoint, nil
+}
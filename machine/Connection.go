@@ -0,0 +1,328 @@
+package machine
+
+import (
+	"compress/flate"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"time"
+)
+
+const (
+	// a connection that lived this long resets the reconnection backoff
+	stableConnection = time.Minute
+	// heartbeats, a connection without pongs for pongWait is considered dead
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = 20 * time.Second
+)
+
+var (
+	NotConnectedErr = errors.New("not connected to the backend")
+	StaleSessionErr = errors.New("the request arrived on a previous connection")
+)
+
+/*
+*
+keeps reconnecting to the backend with exponential backoff and jitter
+*/
+func (m *Machine) connect() {
+	backoff := Backoff{Min: time.Second, Max: 2 * time.Minute}
+	for {
+		connectedAt := time.Now()
+		err := m.session()
+		if time.Since(connectedAt) >= stableConnection {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		m.logger().Error("disconnected, reconnecting in "+wait.String()+": ", err)
+		time.Sleep(wait)
+	}
+}
+
+/*
+*
+runs a single websocket connection until it breaks
+*/
+func (m *Machine) session() (err error) {
+	params, err := m.getLoginString()
+	if err != nil {
+		m.logger().Error("unable to get login string", err)
+		return err
+	}
+	u, err := m.Config.EndpointUrl()
+	if err != nil {
+		m.logger().Error("invalid endpoint", err)
+		return err
+	}
+	dialer, err := m.Config.Dialer()
+	if err != nil {
+		m.logger().Error("unable to set up transport", err)
+		return err
+	}
+	token, err := m.GetToken()
+	if err != nil {
+		m.logger().Error("unable to read token", err)
+		return err
+	}
+	nonce := newNonce()
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+*token)
+	header.Set(nonceHeader, nonce)
+	versions, capabilities := offerProtocol()
+	header.Set(protocolHeader, versions)
+	header.Set(capabilitiesHeader, capabilities)
+	u.RawQuery = params.Encode()
+	m.logger().Info("connecting to " + u.Host)
+	dial, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		m.logger().Error("unable to get dial socket", err)
+		return err
+	}
+	if !m.Config.DisableCompression {
+		dial.EnableWriteCompression(true)
+		_ = dial.SetCompressionLevel(flate.BestSpeed)
+	}
+	generation := m.attach(dial)
+	defer dial.Close()
+	defer m.detach(dial)
+	auth, err := m.authenticate(dial, *token, nonce)
+	if err != nil {
+		m.logger().Error("unable to authenticate", err)
+		return err
+	}
+	protocol := m.currentProtocol()
+	m.logger().Info("connected (protocol v" + strconv.Itoa(protocol.Version) + ", " + protocol.Encoding + ")")
+	m.health.connected()
+	defer m.health.disconnected()
+	_ = dial.SetReadDeadline(time.Now().Add(pongWait))
+	dial.SetPongHandler(func(payload string) error {
+		sentAt, err := strconv.ParseInt(payload, 10, 64)
+		if err == nil {
+			m.health.pong(time.Unix(0, sentAt))
+		} else {
+			m.health.pong(time.Time{})
+		}
+		m.logger().Debug("pong received, round trip ", m.health.Snapshot().RoundTrip)
+		return dial.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	if auth.Replay != nil {
+		// before the event pump starts, so missed entries go out ahead of new ones
+		replayed, err := m.replay(*auth.Replay)
+		if err != nil {
+			m.logger().Error("unable to replay missed events", err)
+			return err
+		}
+		err = m.write(out.Response{
+			Type: "replay",
+			Data: replayed,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	// heartbeat
+	go m.heartbeat(dial, done)
+	// handle events
+	go func() {
+		for {
+			entry, ok := m.events.Pop(done)
+			if !ok {
+				return
+			}
+			err := m.sendEvent(entry)
+			if err != nil {
+				m.logger().Error("unable to forward event (socket likely closed)", err)
+				return
+			}
+		}
+	}()
+	for {
+		messageType, inBytes, err := dial.ReadMessage()
+		if err != nil {
+			m.logger().Error("unable to read message (socket likely closed)", err)
+			return err
+		}
+		_ = dial.SetReadDeadline(time.Now().Add(pongWait))
+		message, err := protocol.decode(messageType, inBytes)
+		if err != nil {
+			m.logger().Warn("malformed message received ("+string(inBytes)+")", err.Error())
+			continue
+		}
+		err = auth.Verify(message)
+		if err != nil {
+			m.logger().Warn("rejected message "+message.Rid+": ", err)
+			_ = m.writeSession(generation, out.Response{
+				Rid:     message.Rid,
+				Type:    "ack",
+				Error:   true,
+				Failure: failure(err),
+			})
+			continue
+		}
+		m.requestLogger(message).Info("received request")
+		m.dispatch(generation, message)
+	}
+}
+
+/*
+*
+acks the message as queued or started and runs it on the dispatcher, operations
+on the same container never interleave. the ack always goes out before the
+command's reply and completion. replies only go out on the connection the
+message arrived on
+*/
+func (m *Machine) dispatch(generation uint64, message in.Message) {
+	acked := make(chan struct{})
+	queued, err := m.dispatcher.Submit(queueKey(message), func() {
+		<-acked
+		m.execute(generation, message)
+	})
+	if err != nil {
+		m.requestLogger(message).Warn("rejected request: ", err)
+		err = m.writeSession(generation, out.Response{
+			Rid:     message.Rid,
+			Type:    "ack",
+			Error:   true,
+			Failure: failure(err),
+		})
+	} else {
+		state := "started"
+		if queued {
+			state = "queued"
+		}
+		err = m.writeSession(generation, out.Response{
+			Rid:   message.Rid,
+			Type:  "ack",
+			State: state,
+		})
+	}
+	close(acked)
+	if err != nil {
+		m.requestLogger(message).Warn("error while encoding ack: " + err.Error())
+	}
+}
+
+func (m *Machine) execute(generation uint64, message in.Message) {
+	logger := m.requestLogger(message)
+	reply, err := m.handle(message)
+	if err != nil {
+		logger.Warn("request failed: ", err)
+	}
+	if reply != nil {
+		writeErr := m.writeSession(generation, reply)
+		if writeErr != nil {
+			logger.Warnf("error while replying: %v", writeErr)
+		}
+	}
+	writeErr := m.writeSession(generation, out.Response{
+		Rid:     message.Rid,
+		Type:    "complete",
+		Error:   err != nil,
+		Failure: failure(err),
+	})
+	if writeErr != nil {
+		logger.Warn("error while encoding completion: " + writeErr.Error())
+		return
+	}
+	if reply != nil {
+		logger = logger.WithField("reply", reply.Type)
+	}
+	logger.Info("fulfilled request")
+}
+
+/*
+*
+makes the dialed connection the one every write goes to, with the v1 protocol until
+the handshake negotiates another. returns the generation of the connection
+*/
+func (m *Machine) attach(conn *websocket.Conn) (generation uint64) {
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+	m.conn = conn
+	m.protocol = Protocol{Version: ProtocolV1, Encoding: JsonEncoding}
+	m.generation++
+	return m.generation
+}
+
+func (m *Machine) detach(conn *websocket.Conn) {
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+	if m.conn == conn {
+		m.conn = nil
+	}
+}
+
+func (m *Machine) negotiated(protocol Protocol) {
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+	m.protocol = protocol
+}
+
+func (m *Machine) currentProtocol() Protocol {
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+	return m.protocol
+}
+
+/*
+*
+writes a message to the current connection, whichever it is
+*/
+func (m *Machine) write(message interface{}) (err error) {
+	return m.writeSession(0, message)
+}
+
+/*
+*
+writes a message with a deadline, a write that can't complete in time breaks the
+connection so it gets reestablished. a non zero generation limits the write to that
+connection, replies to a request never reach a backend session that didn't send it
+*/
+func (m *Machine) writeSession(generation uint64, message interface{}) (err error) {
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+	if m.conn == nil {
+		return NotConnectedErr
+	}
+	if generation != 0 && generation != m.generation {
+		return StaleSessionErr
+	}
+	messageType, payload, plain, err := m.protocol.frame(message)
+	if err != nil {
+		return err
+	}
+	m.traffic.record(plain, len(payload))
+	_ = m.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err = m.conn.WriteMessage(messageType, payload)
+	if err != nil {
+		_ = m.conn.Close()
+	}
+	return err
+}
+
+func (m *Machine) heartbeat(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			m.outMutex.Lock()
+			err := conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(writeWait))
+			m.outMutex.Unlock()
+			if err != nil {
+				m.logger().Error("unable to ping, dropping connection: ", err)
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
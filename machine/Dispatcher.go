@@ -0,0 +1,133 @@
+package machine
+
+import (
+	"errors"
+	"supervisor/machine/proto/in"
+	"sync"
+	"sync/atomic"
+)
+
+var BusyErr = errors.New("too many commands waiting for a worker, try again later")
+
+// container commands that must not wait behind the container's running operation
+var unqueued = map[string]bool{
+	"cancel_activity": true,
+}
+
+// shared by machine commands, '@' can't appear in a docker name so no container id collides
+const machineQueue = "@machine"
+
+/*
+*
+container commands share their container queue, machine commands (handshake, farewell...)
+share one queue so they keep their arrival order, everything else runs unkeyed
+*/
+func queueKey(message in.Message) string {
+	switch {
+	case message.Realm == "machine":
+		return machineQueue
+	case message.Realm == "container" && message.Target != nil && !unqueued[message.Command]:
+		return *message.Target
+	}
+	return ""
+}
+
+/*
+*
+runs commands on a fixed pool of workers. commands sharing a key (a container)
+are serialized in arrival order, commands without a key run as soon as a worker
+is free
+*/
+type Dispatcher struct {
+	mutex   sync.Mutex
+	pending map[string][]func()
+	work    chan func()
+	workers int
+	running atomic.Int32
+}
+
+func NewDispatcher(workers int, backlog int) *Dispatcher {
+	d := &Dispatcher{
+		pending: make(map[string][]func()),
+		work:    make(chan func(), backlog),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range d.work {
+				d.running.Add(1)
+				task()
+				d.running.Add(-1)
+			}
+		}()
+	}
+	return d
+}
+
+/*
+*
+schedules the task without blocking, queued is true when it has to wait for earlier
+tasks with the same key or for a free worker. a full backlog rejects the task with
+BusyErr
+*/
+func (d *Dispatcher) Submit(key string, task func()) (queued bool, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if key != "" {
+		waiting, busy := d.pending[key]
+		if busy {
+			d.pending[key] = append(waiting, task)
+			return true, nil
+		}
+		task = d.chain(key, task)
+	}
+	queued = int(d.running.Load())+len(d.work) >= d.workers
+	select {
+	case d.work <- task:
+	default:
+		return false, BusyErr
+	}
+	if key != "" {
+		// the chained task can't hand the key over before this, it needs the lock
+		d.pending[key] = make([]func(), 0)
+	}
+	return queued, nil
+}
+
+/*
+*
+runs the task and hands the key over to the next waiting task, if any
+*/
+func (d *Dispatcher) chain(key string, task func()) func() {
+	return func() {
+		task()
+		d.mutex.Lock()
+		waiting := d.pending[key]
+		if len(waiting) <= 0 {
+			delete(d.pending, key)
+			d.mutex.Unlock()
+			return
+		}
+		next := waiting[0]
+		d.pending[key] = waiting[1:]
+		d.mutex.Unlock()
+		// hand over from a fresh goroutine so a full backlog can't block this worker
+		go func() {
+			d.work <- d.chain(key, next)
+		}()
+	}
+}
+
+/*
+*
+tasks waiting behind another task, by key
+*/
+func (d *Dispatcher) Depth() (depth map[string]int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	depth = make(map[string]int, len(d.pending))
+	for key, waiting := range d.pending {
+		depth[key] = len(waiting)
+	}
+	return depth
+}
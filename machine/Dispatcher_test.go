@@ -0,0 +1,117 @@
+package machine
+
+import (
+	"errors"
+	"supervisor/machine/container"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/in"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherSerializesPerKey(t *testing.T) {
+	d := NewDispatcher(4, 64)
+	var mutex sync.Mutex
+	order := make(map[string][]int)
+	running := make(map[string]int)
+	overlapped := false
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"c1", "c2"} {
+			i, key := i, key
+			wg.Add(1)
+			_, err := d.Submit(key, func() {
+				defer wg.Done()
+				mutex.Lock()
+				running[key]++
+				overlapped = overlapped || running[key] > 1
+				order[key] = append(order[key], i)
+				mutex.Unlock()
+				time.Sleep(time.Millisecond)
+				mutex.Lock()
+				running[key]--
+				mutex.Unlock()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	if overlapped {
+		t.Fatal("tasks sharing a key ran at the same time")
+	}
+	for key, ran := range order {
+		for i, task := range ran {
+			if task != i {
+				t.Fatalf("%s ran out of order: %v", key, ran)
+			}
+		}
+	}
+	if depth := d.Depth(); len(depth) != 0 {
+		t.Fatalf("keys left pending after every task ran: %v", depth)
+	}
+}
+
+func TestDispatcherReportsQueuedAndBusy(t *testing.T) {
+	d := NewDispatcher(1, 1)
+	started, release := make(chan struct{}), make(chan struct{})
+	queued, err := d.Submit("c1", func() {
+		close(started)
+		<-release
+	})
+	if err != nil || queued {
+		t.Fatalf("expected the first task to start right away, got %v, %v", queued, err)
+	}
+	<-started
+	queued, err = d.Submit("c1", func() {})
+	if err != nil || !queued {
+		t.Fatalf("expected a task behind its key to be queued, got %v, %v", queued, err)
+	}
+	queued, err = d.Submit("", func() {})
+	if err != nil || !queued {
+		t.Fatalf("expected a task waiting for the only worker to be queued, got %v, %v", queued, err)
+	}
+	rejected := make(chan error)
+	go func() {
+		_, err := d.Submit("", func() {})
+		rejected <- err
+	}()
+	select {
+	case err = <-rejected:
+		if !errors.Is(err, BusyErr) {
+			t.Fatalf("expected a full backlog to reject the task, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submitting to a full backlog blocked")
+	}
+	close(release)
+}
+
+func TestDispatchAcksBeforeTheResult(t *testing.T) {
+	m := &Machine{
+		events:     event.NewQueue(eventLimit),
+		journal:    event.NewJournal(journalCapacity, "", 0),
+		dispatcher: NewDispatcher(commandWorkers, commandBacklog),
+		Containers: container.NewRegistry(),
+	}
+	generation, frames := backendSession(t, m)
+	rids := []string{"r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8"}
+	for _, rid := range rids {
+		m.dispatch(generation, in.Message{Rid: rid, Realm: "machine", Command: "status"})
+	}
+	seen := make(map[string][]string)
+	for i := 0; i < len(rids)*3; i++ {
+		frame := nextFrame(t, frames)
+		rid, _ := frame["rid"].(string)
+		kind, _ := frame["type"].(string)
+		seen[rid] = append(seen[rid], kind)
+	}
+	for _, rid := range rids {
+		got := seen[rid]
+		if len(got) != 3 || got[0] != "ack" || got[1] != "status" || got[2] != "complete" {
+			t.Fatalf("%s: expected ack, status and complete in order, got %v", rid, got)
+		}
+	}
+}
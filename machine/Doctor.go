@@ -0,0 +1,245 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/websocket"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"supervisor/machine/container"
+	"syscall"
+	"time"
+)
+
+const (
+	doctorTimeout = 10 * time.Second
+	hostingGroup  = "serverbench"
+)
+
+type Check struct {
+	Name   string `json:"name"`
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	// what to do about a failed check
+	Hint string `json:"hint,omitempty"`
+}
+
+/*
+*
+binaries hosting shells out to, and the package that usually provides them.
+the ones hosting calls by absolute path are looked up there
+*/
+var requiredBinaries = []struct {
+	name    string
+	path    string
+	pkg     string
+	iptable bool
+}{
+	{name: "iptables", pkg: "iptables", iptable: true},
+	{name: "ip6tables", pkg: "iptables", iptable: true},
+	{name: "useradd", path: "/usr/sbin/useradd", pkg: "passwd"},
+	{name: "userdel", path: "/usr/sbin/userdel", pkg: "passwd"},
+	{name: "chpasswd", path: "/usr/sbin/chpasswd", pkg: "passwd"},
+	{name: "groupadd", pkg: "passwd"},
+	{name: "rsync", pkg: "rsync"},
+	{name: "sshpass", pkg: "sshpass"},
+	{name: "git", pkg: "git"},
+	{name: "ssh-keygen", pkg: "openssh-client"},
+	{name: "mount", pkg: "mount"},
+}
+
+/*
+*
+runs every preflight check hosting depends on. checks never modify the machine,
+apart from a throwaway bind mount under the temp directory
+*/
+func (m *Machine) Diagnose() (checks []Check) {
+	checks = append(checks, m.checkDocker())
+	for _, binary := range requiredBinaries {
+		checks = append(checks, checkBinary(binary.name, binary.path, binary.pkg, binary.iptable))
+	}
+	checks = append(checks, checkSshdConfig())
+	checks = append(checks, checkBindMount())
+	checks = append(checks, m.checkToken())
+	checks = append(checks, checkGroup())
+	checks = append(checks, m.checkEndpoint())
+	return checks
+}
+
+func (m *Machine) checkDocker() (check Check) {
+	check.Name = "docker"
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "check DOCKER_HOST and the docker client environment"
+		return check
+	}
+	defer cli.Close()
+	timeout, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	version, err := cli.ServerVersion(timeout)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "start the docker daemon (systemctl start docker) and make sure this user can reach its socket"
+		return check
+	}
+	check.Ok = true
+	check.Detail = "server " + version.Version + ", api " + version.APIVersion
+	return check
+}
+
+func checkBinary(name string, path string, pkg string, iptable bool) (check Check) {
+	check.Name = name
+	if path == "" {
+		path = name
+	}
+	binary, err := exec.LookPath(path)
+	if err != nil {
+		check.Detail = path + " not found"
+		check.Hint = "install the " + pkg + " package"
+		return check
+	}
+	check.Detail = binary
+	if iptable {
+		// listing the filter table needs both the binary and the privileges hosting needs
+		output, err := exec.Command(binary, "-w", "-n", "-L").CombinedOutput()
+		if err != nil {
+			check.Detail = strings.TrimSpace(string(output))
+			if check.Detail == "" {
+				check.Detail = err.Error()
+			}
+			check.Hint = "run as root and make sure the kernel " + name + " modules are available"
+			return check
+		}
+	}
+	check.Ok = true
+	return check
+}
+
+func checkSshdConfig() (check Check) {
+	check.Name = "sshd_config"
+	check.Detail = container.SshdConfig
+	_, err := os.Stat(container.SshdConfig)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "install openssh-server, sftp access is served through the system sshd"
+		return check
+	}
+	err = syscall.Access(container.SshdConfig, 2) // W_OK
+	if err != nil {
+		check.Detail = container.SshdConfig + " is not writable: " + err.Error()
+		check.Hint = "run as root, the sftp jail is appended to the sshd config"
+		return check
+	}
+	check.Ok = true
+	return check
+}
+
+func checkBindMount() (check Check) {
+	check.Name = "bind mount"
+	source, err := os.MkdirTemp("", "sb-doctor-source")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	defer os.RemoveAll(source)
+	target, err := os.MkdirTemp("", "sb-doctor-target")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	defer os.RemoveAll(target)
+	output, err := exec.Command("mount", "--bind", source, target).CombinedOutput()
+	if err != nil {
+		check.Detail = strings.TrimSpace(string(output))
+		if check.Detail == "" {
+			check.Detail = err.Error()
+		}
+		check.Hint = "run as root, outside of an unprivileged container, sftp homes are bind mounted"
+		return check
+	}
+	output, err = exec.Command("umount", target).CombinedOutput()
+	if err != nil {
+		check.Detail = "mounted but unable to unmount " + target + ": " + strings.TrimSpace(string(output))
+		return check
+	}
+	check.Ok = true
+	return check
+}
+
+func (m *Machine) checkToken() (check Check) {
+	check.Name = "token"
+	check.Detail = TokenPath
+	info, err := os.Stat(TokenPath)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "set the machine token with sb token set (reads stdin)"
+		return check
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		check.Detail = TokenPath + " is readable by other users (" + info.Mode().Perm().String() + ")"
+		check.Hint = "restrict it with chmod 0600 " + TokenPath
+		return check
+	}
+	_, err = m.GetToken()
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "set the machine token with sb token set (reads stdin)"
+		return check
+	}
+	check.Ok = true
+	return check
+}
+
+func checkGroup() (check Check) {
+	check.Name = "group"
+	_, err := user.LookupGroup(hostingGroup)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "create it with groupadd -f " + hostingGroup
+		return check
+	}
+	check.Ok = true
+	check.Detail = hostingGroup
+	return check
+}
+
+/*
+*
+dials the backend without credentials, a rejected handshake still proves dns, tls
+and pinning work
+*/
+func (m *Machine) checkEndpoint() (check Check) {
+	check.Name = "endpoint"
+	endpoint, err := m.Config.EndpointUrl()
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "fix the endpoint in " + DefaultConfigPath + " or --endpoint"
+		return check
+	}
+	check.Detail = endpoint.String()
+	dialer, err := m.Config.Dialer()
+	if err != nil {
+		check.Detail = err.Error()
+		check.Hint = "check the ca bundle in the config"
+		return check
+	}
+	dialer.HandshakeTimeout = doctorTimeout
+	conn, response, err := dialer.Dial(endpoint.String(), nil)
+	if err == nil {
+		conn.Close()
+	} else if !errors.Is(err, websocket.ErrBadHandshake) || response == nil {
+		check.Detail = err.Error()
+		if errors.Is(err, PinMismatchErr) {
+			check.Hint = "the backend certificate changed, update the pins in the config"
+		} else {
+			check.Hint = "check dns, outbound connectivity and the ca bundle for " + endpoint.Host
+		}
+		return check
+	}
+	check.Ok = true
+	return check
+}
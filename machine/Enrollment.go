@@ -0,0 +1,178 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// poll pacing, variables so tests don't have to wait on it
+var (
+	defaultEnrollmentInterval = 5 * time.Second
+	// added to the poll interval each time the backend asks to slow down
+	enrollmentSlowDown = 5 * time.Second
+)
+
+var (
+	EnrollmentDeniedErr  = errors.New("the enrollment was denied")
+	EnrollmentExpiredErr = errors.New("the enrollment code expired before it was approved")
+)
+
+type EnrollmentState string
+
+const (
+	EnrollmentPending  EnrollmentState = "pending"
+	EnrollmentApproved EnrollmentState = "approved"
+	EnrollmentDenied   EnrollmentState = "denied"
+	EnrollmentExpired  EnrollmentState = "expired"
+)
+
+/*
+*
+a pending enrollment. the code is shown to the operator, the id is only used to poll
+*/
+type Enrollment struct {
+	Id              string    `json:"id"`
+	Code            string    `json:"code"`
+	VerificationUrl string    `json:"verificationUrl,omitempty"`
+	Interval        int       `json:"interval,omitempty"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+}
+
+type enrollmentRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+type enrollmentStatus struct {
+	State EnrollmentState `json:"state"`
+	// only set once approved
+	Token string `json:"token,omitempty"`
+}
+
+type EnrollmentClient struct {
+	Config Config
+	http   *http.Client
+}
+
+func NewEnrollmentClient(config Config) (client *EnrollmentClient, err error) {
+	httpClient, err := config.HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &EnrollmentClient{Config: config, http: httpClient}, nil
+}
+
+/*
+*
+asks the backend for a new enrollment code for this machine
+*/
+func (c *EnrollmentClient) Request(ctx context.Context, hostname string) (enrollment *Enrollment, err error) {
+	api, err := c.Config.ApiUrl("enrollment")
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(enrollmentRequest{Hostname: hostname})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, api.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	enrollment = &Enrollment{}
+	_, err = c.do(request, enrollment)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Id == "" || enrollment.Code == "" {
+		return nil, errors.New("the backend returned an incomplete enrollment")
+	}
+	return enrollment, nil
+}
+
+/*
+*
+polls until the enrollment is approved and returns the issued token. denial, expiry
+and context cancellation end the wait. transport errors and 5xx responses are retried,
+the code stays valid until it expires so a backend hiccup doesn't mean starting over
+*/
+func (c *EnrollmentClient) Await(ctx context.Context, enrollment *Enrollment) (token string, err error) {
+	api, err := c.Config.ApiUrl("enrollment", enrollment.Id)
+	if err != nil {
+		return "", err
+	}
+	interval := time.Duration(enrollment.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultEnrollmentInterval
+	}
+	if !enrollment.ExpiresAt.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, enrollment.ExpiresAt)
+		defer cancel()
+	}
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if lastErr != nil {
+					return "", fmt.Errorf("%w (last error: %v)", EnrollmentExpiredErr, lastErr)
+				}
+				return "", EnrollmentExpiredErr
+			}
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, api.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		status := enrollmentStatus{}
+		code, err := c.do(request, &status)
+		if code == http.StatusTooManyRequests {
+			interval += enrollmentSlowDown
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			if code >= 400 && code < 500 {
+				return "", err
+			}
+			lastErr = err
+			continue
+		}
+		lastErr = nil
+		switch status.State {
+		case EnrollmentApproved:
+			if status.Token == "" {
+				return "", errors.New("the enrollment was approved without a token")
+			}
+			return status.Token, nil
+		case EnrollmentDenied:
+			return "", EnrollmentDeniedErr
+		case EnrollmentExpired:
+			return "", EnrollmentExpiredErr
+		}
+	}
+}
+
+func (c *EnrollmentClient) do(request *http.Request, target interface{}) (code int, err error) {
+	response, err := c.http.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return response.StatusCode, fmt.Errorf("enrollment request failed with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(target)
+}
@@ -0,0 +1,173 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+*
+one poll answer of the stand-in backend, drop closes the connection without a response
+*/
+type pollAnswer struct {
+	code  int
+	state EnrollmentState
+	token string
+	drop  bool
+}
+
+/*
+*
+stand-in backend handing out a single enrollment and answering polls from a script,
+the last answer repeats once the script runs out
+*/
+type enrollmentBackend struct {
+	mutex     sync.Mutex
+	answers   []pollAnswer
+	polls     []time.Time
+	expiresAt time.Time
+}
+
+func (b *enrollmentBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/machine/enrollment"):
+		_ = json.NewEncoder(w).Encode(Enrollment{Id: "e1", Code: "ABCD-1234", ExpiresAt: b.expiresAt})
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/machine/enrollment/e1"):
+		b.mutex.Lock()
+		b.polls = append(b.polls, time.Now())
+		answer := b.answers[0]
+		if len(b.answers) > 1 {
+			b.answers = b.answers[1:]
+		}
+		b.mutex.Unlock()
+		if answer.drop {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if answer.code != 0 && answer.code != http.StatusOK {
+			http.Error(w, http.StatusText(answer.code), answer.code)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(enrollmentStatus{State: answer.state, Token: answer.token})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *enrollmentBackend) pollTimes() []time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]time.Time{}, b.polls...)
+}
+
+func enroll(t *testing.T, backend *enrollmentBackend) (token string, err error) {
+	interval, slowDown := defaultEnrollmentInterval, enrollmentSlowDown
+	defaultEnrollmentInterval, enrollmentSlowDown = 10*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() {
+		defaultEnrollmentInterval, enrollmentSlowDown = interval, slowDown
+	})
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+	client, err := NewEnrollmentClient(Config{Endpoint: "ws" + strings.TrimPrefix(server.URL, "http") + "/machine", Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	enrollment, err := client.Request(ctx, "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Await(ctx, enrollment)
+}
+
+func TestEnrollmentApproved(t *testing.T) {
+	backend := &enrollmentBackend{answers: []pollAnswer{
+		{state: EnrollmentPending},
+		{state: EnrollmentApproved, token: "issued-token"},
+	}}
+	token, err := enroll(t, backend)
+	if err != nil || token != "issued-token" {
+		t.Fatalf("expected the issued token, got %q, %v", token, err)
+	}
+}
+
+func TestEnrollmentDenied(t *testing.T) {
+	backend := &enrollmentBackend{answers: []pollAnswer{
+		{state: EnrollmentPending},
+		{state: EnrollmentDenied},
+	}}
+	_, err := enroll(t, backend)
+	if !errors.Is(err, EnrollmentDeniedErr) {
+		t.Fatalf("expected a denial, got %v", err)
+	}
+}
+
+func TestEnrollmentExpired(t *testing.T) {
+	t.Run("reported", func(t *testing.T) {
+		backend := &enrollmentBackend{answers: []pollAnswer{{state: EnrollmentExpired}}}
+		_, err := enroll(t, backend)
+		if !errors.Is(err, EnrollmentExpiredErr) {
+			t.Fatalf("expected an expiry, got %v", err)
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		backend := &enrollmentBackend{
+			answers:   []pollAnswer{{state: EnrollmentPending}},
+			expiresAt: time.Now().Add(200 * time.Millisecond),
+		}
+		_, err := enroll(t, backend)
+		if !errors.Is(err, EnrollmentExpiredErr) {
+			t.Fatalf("expected an expiry, got %v", err)
+		}
+	})
+}
+
+func TestEnrollmentSlowDown(t *testing.T) {
+	backend := &enrollmentBackend{answers: []pollAnswer{
+		{code: http.StatusTooManyRequests},
+		{state: EnrollmentApproved, token: "issued-token"},
+	}}
+	token, err := enroll(t, backend)
+	if err != nil || token != "issued-token" {
+		t.Fatalf("expected the issued token, got %q, %v", token, err)
+	}
+	polls := backend.pollTimes()
+	if len(polls) != 2 {
+		t.Fatalf("expected 2 polls, got %d", len(polls))
+	}
+	if gap := polls[1].Sub(polls[0]); gap < enrollmentSlowDown {
+		t.Fatalf("polled again after %s, the backend asked to slow down", gap)
+	}
+}
+
+func TestEnrollmentRetriesTransientFailures(t *testing.T) {
+	backend := &enrollmentBackend{answers: []pollAnswer{
+		{drop: true},
+		{code: http.StatusBadGateway},
+		{code: http.StatusServiceUnavailable},
+		{state: EnrollmentApproved, token: "issued-token"},
+	}}
+	token, err := enroll(t, backend)
+	if err != nil || token != "issued-token" {
+		t.Fatalf("expected the issued token after retrying, got %q, %v", token, err)
+	}
+}
+
+func TestEnrollmentStopsOnClientErrors(t *testing.T) {
+	backend := &enrollmentBackend{answers: []pollAnswer{{code: http.StatusNotFound}}}
+	_, err := enroll(t, backend)
+	if err == nil || len(backend.pollTimes()) != 1 {
+		t.Fatalf("expected the first 404 to end the enrollment, got %v after %d polls", err, len(backend.pollTimes()))
+	}
+}
@@ -0,0 +1,110 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"os"
+	"os/exec"
+	"supervisor/machine/container"
+	"supervisor/machine/container/ip"
+	"supervisor/machine/container/listener"
+	"supervisor/machine/container/listener/activity"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+)
+
+var (
+	MissingContainerListErr = errors.New("you must provide a container list")
+	ContainerNotFoundErr    = errors.New("container not found")
+	TargetMismatchErr       = errors.New("target mismatch (while creating new container)")
+	MissingTargetErr        = errors.New("you must provide a hosted container id (unless you are hosting a new container)")
+	UnknownCommandErr       = errors.New("unknown command")
+)
+
+// stable codes reported to the panel, checked in order
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	// machine
+	{MissingContainerListErr, "missing_container_list"},
+	{ContainerNotFoundErr, "container_not_found"},
+	{TargetMismatchErr, "target_mismatch"},
+	{MissingTargetErr, "missing_target"},
+	{in.MissingDataErr, "missing_data"},
+	{UnknownCommandErr, "unknown_command"},
+	{InvalidSignatureErr, "invalid_signature"},
+	{ReplayedMessageErr, "replayed_message"},
+	{NotConnectedErr, "not_connected"},
+	{BusyErr, "busy"},
+	{EmptyTokenErr, "empty_token"},
+	// container
+	{container.AlreadyInitializedErr, "container_already_initialized"},
+	{container.MissingStateErr, "container_state_unavailable"},
+	{container.FrozenErr, "container_frozen"},
+	{container.MissingBranchErr, "missing_branch"},
+	{container.MissingRepositoryErr, "missing_repository"},
+	{container.MissingGitTokenErr, "missing_git_token"},
+	{container.InvalidGitTokenErr, "invalid_git_token"},
+	{container.RootUnsupportedErr, "root_unsupported"},
+	{container.KeyTooLongErr, "key_too_long"},
+	{container.InvalidKeyErr, "invalid_key"},
+	{container.KeyNotFoundErr, "key_not_found"},
+	// firewall
+	{ip.SshPortErr, "ssh_port"},
+	{ip.InvalidSourceErr, "invalid_source_ip"},
+	{ip.UnknownPolicyErr, "unknown_firewall_policy"},
+	// listener
+	{listener.MissingStatusErr, "missing_status_subscription"},
+	{listener.AlreadyForwardingErr, "already_forwarding"},
+	{listener.NotForwardingErr, "not_forwarding"},
+	{listener.UnknownEventErr, "unknown_event"},
+	{listener.MissingWatcherErr, "watcher_not_found"},
+	{listener.InvalidWatcherErr, "invalid_watcher"},
+	{listener.InvalidReadinessErr, "invalid_readiness"},
+	{listener.MissingActivityErr, "activity_not_found"},
+	{activity.CancelledErr, "cancelled"},
+}
+
+/*
+*
+maps an error to the code/message pair sent back to the panel
+*/
+func failure(err error) *out.ErrorResponse {
+	if err == nil {
+		return nil
+	}
+	response := &out.ErrorResponse{
+		Code:    "internal",
+		Message: err.Error(),
+	}
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			response.Code = known.code
+			return response
+		}
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		response.Code = "malformed_data"
+	case client.IsErrConnectionFailed(err):
+		response.Code = "docker_unreachable"
+	case errdefs.IsNotFound(err):
+		response.Code = "docker_not_found"
+	case errdefs.IsConflict(err):
+		response.Code = "docker_conflict"
+	case errors.As(err, &exitErr):
+		response.Code = "command_failed"
+		response.Details = map[string]int{"exitCode": exitErr.ExitCode()}
+	case errors.Is(err, os.ErrNotExist):
+		response.Code = "not_found"
+	case errors.Is(err, os.ErrPermission):
+		response.Code = "permission_denied"
+	}
+	return response
+}
@@ -1,15 +1,48 @@
 package machine
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"supervisor/machine/container"
+	"go.opentelemetry.io/otel/attribute"
 	"supervisor/machine/container/listener"
+	"supervisor/machine/metrics"
 	"supervisor/machine/proto/in"
 	"supervisor/machine/proto/out"
+	"supervisor/machine/tracing"
+	"time"
 )
 
-func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err error) {
+/*
+*
+runs a command in its own span and records its result and latency. unknown realms
+and commands share a label so the backend can't grow the series
+*/
+func (m *Machine) handle(message in.Message) (reply *out.Response, err error) {
+	started := time.Now()
+	attributes := []attribute.KeyValue{
+		attribute.String("serverbench.rid", message.Rid),
+		attribute.String("serverbench.realm", message.Realm),
+		attribute.String("serverbench.command", message.Command),
+	}
+	if message.Target != nil {
+		attributes = append(attributes, attribute.String("serverbench.container", *message.Target))
+	}
+	ctx, span := tracing.Start(context.Background(), message.Realm+" "+message.Command, attributes...)
+	reply, err = m.handleMessage(ctx, message)
+	tracing.End(span, err)
+	realm, command := message.Realm, message.Command
+	if errors.Is(err, UnknownCommandErr) {
+		realm, command = "unknown", "unknown"
+	}
+	result := "ok"
+	if err != nil {
+		result = failure(err).Code
+	}
+	metrics.ObserveCommand(realm, command, result, time.Since(started))
+	return reply, err
+}
+
+func (m *Machine) handleMessage(ctx context.Context, message in.Message) (reply *out.Response, err error) {
 	switch message.Realm {
 	case "machine":
 		{
@@ -17,32 +50,75 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 			case "handshake":
 				{
 					subscriber := listener.Subscriber{}
-					err = json.Unmarshal([]byte(*message.Data), &subscriber)
+					err = message.Decode(&subscriber)
+					if err != nil {
+						return nil, err
+					}
 					if subscriber.Containers == nil {
-						err = errors.New("you must provide a container list")
+						return nil, MissingContainerListErr
 					}
+					for _, containerId := range *subscriber.Containers {
+						subscribed, ok := m.Containers.Get(containerId)
+						if !ok {
+							err = ContainerNotFoundErr
+							return nil, err
+						}
+						err = subscribed.Handler.Subscribe(subscriber)
+						if err != nil {
+							return nil, err
+						}
+					}
+					break
+				}
+			case "status":
+				{
+					reply = m.status(message.Rid)
+					break
+				}
+			case "info":
+				{
+					reply = &out.Response{
+						Rid:   message.Rid,
+						Type:  "info",
+						Data:  m.currentInventory(),
+						Error: false,
+					}
+					break
+				}
+			case "replay":
+				{
+					replayRequest := in.ReplayRequest{}
+					err = message.Decode(&replayRequest)
 					if err == nil {
-						for _, containerId := range *subscriber.Containers {
-							_, ok := m.Containers[containerId]
-							if !ok {
-								err = errors.New("container not found")
-								return nil, err
-							}
-							err = m.Containers[containerId].Handler.Subscribe(subscriber)
-							if err != nil {
-								return nil, err
+						var replayed *out.ReplayResponse
+						replayed, err = m.replay(replayRequest)
+						if err == nil {
+							reply = &out.Response{
+								Rid:   message.Rid,
+								Type:  "replay",
+								Data:  replayed,
+								Error: false,
 							}
 						}
 					}
 					break
 				}
+			case "rotate_token":
+				{
+					tokenRequest := in.TokenRequest{}
+					err = message.Decode(&tokenRequest)
+					if err == nil {
+						err = m.RotateToken(tokenRequest.Token)
+					}
+					break
+				}
 			case "farewell":
 				{
 					subscriber := listener.Subscriber{}
-					err = json.Unmarshal([]byte(*message.Data), &subscriber)
+					err = message.Decode(&subscriber)
 					if err == nil {
-						for _, presentContainer := range m.Containers {
-							err := presentContainer.Handler.Unsubscribe(subscriber)
+						for _, presentContainer := range m.Containers.Snapshot() {
+							err = presentContainer.Handler.Unsubscribe(subscriber)
 							if err != nil {
 								break
 							}
@@ -50,39 +126,41 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 					}
 					break
 				}
+			default:
+				err = UnknownCommandErr
 			}
 			break
 		}
 	case "container":
 		{
-			target, ok := m.Containers[*message.Target]
+			if message.Target == nil {
+				return nil, MissingTargetErr
+			}
+			target, ok := m.Containers.Get(*message.Target)
+			hostRequest := in.HostRequest{}
 			if message.Command == "host" {
-				target = container.Container{
-					Id: *message.Target,
-				}
-				hostRequest := in.HostRequest{}
-				err = json.Unmarshal([]byte(*message.Data), &hostRequest)
-				if err != nil && hostRequest.Container.Id != *message.Target {
-					err = errors.New("target mismatch (while creating new container)")
-				} else {
-					target = hostRequest.Container
+				err = message.Decode(&hostRequest)
+				if err == nil && hostRequest.Container.Id != *message.Target {
+					err = TargetMismatchErr
+				}
+				if err == nil {
+					target = &hostRequest.Container
 					// TODO keep handler working between installs
-					err := target.Init(&m.events, m.cli)
-					if err != nil {
-						return nil, err
-					}
+					err = target.Init(m.events, m.cli)
 				}
 			} else if !ok {
-				err = errors.New("you must provide a hosted container id (unless you are hosting a new container)")
+				err = ContainerNotFoundErr
 			}
 			if err != nil {
 				return nil, err
 			}
+			// unqueued commands run next to the container's operation and must not take over its trace
+			if !unqueued[message.Command] {
+				defer target.Trace(ctx, tracedFields(ctx, message))()
+			}
 			switch message.Command {
 			case "host":
 				{
-					hostRequest := in.HostRequest{}
-					err = json.Unmarshal([]byte(*message.Data), &hostRequest)
 					err = target.Host(m.cli, m.Containers, hostRequest.Token, hostRequest.HeadSha)
 					break
 				}
@@ -93,7 +171,8 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				}
 			case "password":
 				{
-					pswd, err := target.ResetPassword()
+					var pswd *string
+					pswd, err = target.ResetPassword()
 					if err == nil {
 						reply = &out.Response{
 							Rid:  message.Rid,
@@ -108,7 +187,8 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				}
 			case "reset_key":
 				{
-					key, err := target.ResetKeys()
+					var key string
+					key, err = target.ResetKeys()
 					if err == nil {
 						reply = &out.Response{
 							Rid:  message.Rid,
@@ -123,7 +203,8 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				}
 			case "public_key":
 				{
-					key, err := target.GetPublicKey()
+					var key string
+					key, err = target.GetPublicKey()
 					if err == nil {
 						reply = &out.Response{
 							Rid:  message.Rid,
@@ -138,7 +219,8 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				}
 			case "list_authorized_keys":
 				{
-					keys, err := target.ListAuthorizedKeys()
+					var keys []string
+					keys, err = target.ListAuthorizedKeys()
 					if err == nil {
 						reply = &out.Response{
 							Rid:  message.Rid,
@@ -154,24 +236,69 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 			case "authorize_key":
 				{
 					keyRequest := in.KeyRequest{}
-					err = json.Unmarshal([]byte(*message.Data), &keyRequest)
+					err = message.Decode(&keyRequest)
+					if err != nil {
+						return nil, err
+					}
 					err = target.AddAuthorizedKey(keyRequest.Key)
 					break
 				}
 			case "deauthorize_key":
 				{
 					keyRequest := in.KeyRequest{}
-					err = json.Unmarshal([]byte(*message.Data), &keyRequest)
+					err = message.Decode(&keyRequest)
+					if err != nil {
+						return nil, err
+					}
 					err = target.RemoveAuthorizedKey(keyRequest.Key)
 					break
 				}
+			case "watch":
+				{
+					watcher := listener.Watcher{}
+					err = message.Decode(&watcher)
+					if err == nil {
+						err = target.Handler.AddWatcher(watcher)
+					}
+					break
+				}
+			case "unwatch":
+				{
+					watcherRequest := in.WatcherRequest{}
+					err = message.Decode(&watcherRequest)
+					if err == nil {
+						err = target.Handler.RemoveWatcher(watcherRequest.Id)
+					}
+					break
+				}
+			case "list_watchers":
+				{
+					reply = &out.Response{
+						Rid:  message.Rid,
+						Type: "watchers",
+						Data: out.WatcherListResponse{
+							Watchers: target.Handler.ListWatchers(),
+						},
+						Error: false,
+					}
+					break
+				}
+			case "cancel_activity":
+				{
+					activityRequest := in.ActivityRequest{}
+					err = message.Decode(&activityRequest)
+					if err == nil {
+						err = target.Handler.CancelActivity(activityRequest.Id)
+					}
+					break
+				}
 			case "transfer":
 				{
 					transferRequest := in.TransferRequest{}
-					err = json.Unmarshal([]byte(*message.Data), &transferRequest)
-					go func() {
-						_ = target.Transfer(transferRequest.Out, transferRequest.Address, transferRequest.Port, transferRequest.Path, transferRequest.User, transferRequest.Mirror, transferRequest.Password, nil)
-					}()
+					err = message.Decode(&transferRequest)
+					if err == nil {
+						err = target.Transfer(transferRequest.Out, transferRequest.Address, transferRequest.Port, transferRequest.Path, transferRequest.User, transferRequest.Mirror, transferRequest.Password, transferRequest.HeadSha)
+					}
 					break
 				}
 			// power
@@ -180,6 +307,11 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 					err = target.Start(m.cli, nil, nil)
 					break
 				}
+			case "power_on":
+				{
+					err = target.PowerOn(m.cli)
+					break
+				}
 			case "stop":
 				{
 					err = target.Stop(m.cli)
@@ -187,6 +319,7 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				}
 			case "restart":
 				{
+					err = target.Restart(m.cli)
 					break
 				}
 			case "pause":
@@ -201,9 +334,39 @@ func (m *Machine) handleMessage(message in.Message) (reply *out.Response, err er
 				{
 					break
 				}
+			default:
+				err = UnknownCommandErr
 			}
 			break
 		}
+	default:
+		err = UnknownCommandErr
 	}
 	return reply, err
 }
+
+func (m *Machine) status(rid string) (reply *out.Response) {
+	health := m.health.Snapshot()
+	protocol := m.currentProtocol()
+	queue := make(map[string]int)
+	for eventType, depth := range m.events.Depth() {
+		queue[string(eventType)] = depth
+	}
+	return &out.Response{
+		Rid:  rid,
+		Type: "status",
+		Data: out.StatusResponse{
+			Connected:    health.Connected,
+			ConnectedAt:  health.ConnectedAt,
+			LastPong:     health.LastPong,
+			RoundTrip:    health.RoundTrip.Milliseconds(),
+			Reconnects:   health.Reconnects,
+			Containers:   m.Containers.Len(),
+			Queue:        queue,
+			Sequence:     m.journal.Sequence(),
+			Protocol:     protocol.Version,
+			Capabilities: protocol.Capabilities,
+		},
+		Error: false,
+	}
+}
@@ -0,0 +1,25 @@
+package machine
+
+import (
+	"context"
+	"supervisor/machine/container"
+	"supervisor/machine/proto/in"
+	"testing"
+)
+
+func TestMalformedDataIsReportedAsSuch(t *testing.T) {
+	m := &Machine{Containers: container.NewRegistry()}
+	m.Containers.Put(&container.Container{Id: "c1"})
+	target := "c1"
+	malformed := `{"containers": [`
+	for _, message := range []in.Message{
+		{Rid: "r1", Realm: "machine", Command: "handshake", Data: &malformed},
+		{Rid: "r2", Realm: "container", Command: "authorize_key", Target: &target, Data: &malformed},
+		{Rid: "r3", Realm: "container", Command: "deauthorize_key", Target: &target, Data: &malformed},
+	} {
+		_, err := m.handleMessage(context.Background(), message)
+		if code := failure(err).Code; code != "malformed_data" {
+			t.Fatalf("%s: expected malformed_data, got %s (%v)", message.Command, code, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package machine
+
+import (
+	"sync"
+	"time"
+)
+
+type Health struct {
+	Connected   bool          `json:"connected"`
+	ConnectedAt time.Time     `json:"connectedAt"`
+	LastPong    time.Time     `json:"lastPong"`
+	RoundTrip   time.Duration `json:"roundTrip"`
+	Reconnects  int           `json:"reconnects"`
+	mutex       sync.Mutex
+}
+
+func (h *Health) connected() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Connected = true
+	h.ConnectedAt = time.Now()
+	h.LastPong = time.Time{}
+	h.RoundTrip = 0
+}
+
+func (h *Health) disconnected() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.Connected {
+		h.Reconnects++
+	}
+	h.Connected = false
+}
+
+func (h *Health) pong(sentAt time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.LastPong = time.Now()
+	if !sentAt.IsZero() {
+		h.RoundTrip = h.LastPong.Sub(sentAt)
+	}
+}
+
+func (h *Health) Snapshot() Health {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return Health{
+		Connected:   h.Connected,
+		ConnectedAt: h.ConnectedAt,
+		LastPong:    h.LastPong,
+		RoundTrip:   h.RoundTrip,
+		Reconnects:  h.Reconnects,
+	}
+}
@@ -0,0 +1,203 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"supervisor/machine/container"
+)
+
+const (
+	ServiceName = "serverbench-supervisor.service"
+	unitPath    = "/etc/systemd/system/" + ServiceName
+)
+
+/*
+*
+a single change install/uninstall makes to the machine. content is shown on dry runs
+for steps that write files
+*/
+type Step struct {
+	Description string
+	Content     string
+	Apply       func() error
+}
+
+/*
+*
+the unit runs sb start with the config it was installed with. restarts are never
+rate limited, the supervisor must come back for as long as the machine is up
+*/
+func ServiceUnit(executable string, configPath string) string {
+	command := executable + " start"
+	if configPath != DefaultConfigPath {
+		command += " --config " + configPath
+	}
+	return `[Unit]
+Description=Serverbench supervisor
+After=network-online.target docker.service
+Wants=network-online.target docker.service
+StartLimitIntervalSec=0
+
+[Service]
+Type=simple
+ExecStart=` + command + `
+Restart=always
+RestartSec=5
+TimeoutStopSec=30
+LimitNOFILE=65536
+
+[Install]
+WantedBy=multi-user.target
+`
+}
+
+/*
+*
+everything install would change, in order. steps that are already satisfied are left out
+*/
+func (m *Machine) InstallPlan(executable string, configPath string) (steps []Step, err error) {
+	// container chroots live under it, sshd requires every component to be root owned and not group writable
+	steps = append(steps, Step{
+		Description: "create " + tokenDirectory + " (root, 0755)",
+		Apply: func() error {
+			err := os.MkdirAll(tokenDirectory, 0755)
+			if err != nil {
+				return err
+			}
+			err = os.Chown(tokenDirectory, 0, 0)
+			if err != nil {
+				return err
+			}
+			return os.Chmod(tokenDirectory, 0755)
+		},
+	})
+	if _, err := os.Stat(stateDirectory); errors.Is(err, os.ErrNotExist) {
+		steps = append(steps, Step{
+			Description: "create " + stateDirectory + " (root, 0700)",
+			Apply: func() error {
+				return os.MkdirAll(stateDirectory, 0700)
+			},
+		})
+	}
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		// keeps endpoint and pins passed to install for the service
+		config, err := json.MarshalIndent(m.Config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, Step{
+			Description: "write " + configPath,
+			Content:     string(config) + "\n",
+			Apply: func() error {
+				err := os.MkdirAll(filepath.Dir(configPath), 0755)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(configPath, append(config, '\n'), 0600)
+			},
+		})
+	}
+	if _, err := os.Stat(TokenPath); err == nil {
+		steps = append(steps, Step{
+			Description: "restrict " + TokenPath + " to root (0600)",
+			Apply: func() error {
+				return os.Chmod(TokenPath, 0600)
+			},
+		})
+	}
+	if _, err := user.LookupGroup(hostingGroup); err != nil {
+		steps = append(steps, Step{
+			Description: "create group " + hostingGroup,
+			Apply: func() error {
+				return command("groupadd", "-f", hostingGroup)
+			},
+		})
+	}
+	original, err := os.ReadFile(container.SshdConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", container.SshdConfig, err)
+	}
+	jailed, updated := container.SshdJail(string(original))
+	if updated {
+		steps = append(steps, Step{
+			Description: "jail sftp users of group " + hostingGroup + " in " + container.SshdConfig + " and restart sshd",
+			Content:     jailed,
+			Apply: func() error {
+				err := os.WriteFile(container.SshdConfig, []byte(jailed), 0644)
+				if err != nil {
+					return err
+				}
+				return restartSshd()
+			},
+		})
+	}
+	unit := ServiceUnit(executable, configPath)
+	steps = append(steps, Step{
+		Description: "write " + unitPath,
+		Content:     unit,
+		Apply: func() error {
+			return os.WriteFile(unitPath, []byte(unit), 0644)
+		},
+	}, Step{
+		Description: "reload systemd",
+		Apply: func() error {
+			return command("systemctl", "daemon-reload")
+		},
+	}, Step{
+		Description: "enable and start " + ServiceName,
+		Apply: func() error {
+			return command("systemctl", "enable", "--now", ServiceName)
+		},
+	})
+	return steps, nil
+}
+
+/*
+*
+removes the service only. the config, token, hosting group and sshd jail stay, hosted
+containers and their sftp users still depend on them
+*/
+func UninstallPlan() (steps []Step) {
+	if _, err := os.Stat(unitPath); err == nil {
+		steps = append(steps, Step{
+			Description: "disable and stop " + ServiceName,
+			Apply: func() error {
+				return command("systemctl", "disable", "--now", ServiceName)
+			},
+		}, Step{
+			Description: "remove " + unitPath,
+			Apply: func() error {
+				return os.Remove(unitPath)
+			},
+		}, Step{
+			Description: "reload systemd",
+			Apply: func() error {
+				return command("systemctl", "daemon-reload")
+			},
+		})
+	}
+	return steps
+}
+
+// debian names the unit ssh, most other distributions sshd
+func restartSshd() (err error) {
+	err = command("systemctl", "restart", "ssh.service")
+	if err != nil {
+		return command("systemctl", "restart", "sshd.service")
+	}
+	return nil
+}
+
+func command(name string, args ...string) (err error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
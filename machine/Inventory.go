@@ -0,0 +1,295 @@
+package machine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/out"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	inventoryInterval = 5 * time.Minute
+	// available capacity changes smaller than this don't count as an inventory change
+	inventoryGranularity = 1 << 30
+)
+
+// set at build time with -ldflags "-X supervisor/machine.Version=..."
+var Version = ""
+
+/*
+*
+the latest collected inventory, refreshed in the background
+*/
+type Inventory struct {
+	latest *out.InventoryResponse
+	mutex  sync.Mutex
+}
+
+func (i *Inventory) Latest() *out.InventoryResponse {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.latest
+}
+
+func (i *Inventory) set(inventory *out.InventoryResponse) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.latest = inventory
+}
+
+/*
+*
+collects the inventory periodically and pushes it to the backend as an event when it
+changed since the last collection. the first one goes out at login
+*/
+func (m *Machine) refreshInventory() {
+	ticker := time.NewTicker(inventoryInterval)
+	for {
+		previous := m.inventory.Latest()
+		inventory := m.collectInventory()
+		m.inventory.set(inventory)
+		if previous != nil && inventoryChanged(previous, inventory) {
+			m.pushInventory(inventory)
+		}
+		<-ticker.C
+	}
+}
+
+func (m *Machine) pushInventory(inventory *out.InventoryResponse) {
+	content, err := json.Marshal(inventory)
+	if err != nil {
+		m.logger().Error("unable to encode inventory: ", err)
+		return
+	}
+	m.events.Push(event.Entry{
+		Listeners: []string{"*"},
+		Type:      event.Inventory,
+		Content:   string(content),
+		Timestamp: time.Now(),
+	})
+}
+
+/*
+*
+whether anything but the collection time changed. available capacity only counts
+when it moves across a GiB, free memory shifts on every read
+*/
+func inventoryChanged(previous *out.InventoryResponse, current *out.InventoryResponse) bool {
+	return !reflect.DeepEqual(comparableInventory(*previous), comparableInventory(*current))
+}
+
+func comparableInventory(inventory out.InventoryResponse) out.InventoryResponse {
+	inventory.CollectedAt = time.Time{}
+	inventory.Memory.Available /= inventoryGranularity
+	disks := make([]out.DiskInventory, len(inventory.Disks))
+	for i, disk := range inventory.Disks {
+		disk.Available /= inventoryGranularity
+		disks[i] = disk
+	}
+	inventory.Disks = disks
+	return inventory
+}
+
+/*
+*
+the latest inventory, collected on the spot if the background refresh hasn't run yet
+*/
+func (m *Machine) currentInventory() *out.InventoryResponse {
+	inventory := m.inventory.Latest()
+	if inventory == nil {
+		inventory = m.collectInventory()
+		m.inventory.set(inventory)
+	}
+	return inventory
+}
+
+/*
+*
+collects everything the backend needs to place containers. parts that can't be read
+are left empty and logged, a partial inventory is still useful
+*/
+func (m *Machine) collectInventory() (inventory *out.InventoryResponse) {
+	inventory = &out.InventoryResponse{
+		Cpu: out.CpuInventory{
+			Model: cpuModel(),
+			Cores: runtime.NumCPU(),
+		},
+		Disks:       m.diskInventory(),
+		Kernel:      kernelRelease(),
+		Os:          osRelease(),
+		Supervisor:  supervisorVersion(),
+		Labels:      m.Config.Labels,
+		CollectedAt: time.Now(),
+	}
+	inventory.Hostname, _ = os.Hostname()
+	memory, err := memoryInventory()
+	if err != nil {
+		m.logger().Warn("unable to read memory inventory: ", err)
+	}
+	inventory.Memory = memory
+	if m.cli != nil {
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		version, err := m.cli.ServerVersion(timeout)
+		if err != nil {
+			m.logger().Warn("unable to read docker version: ", err)
+		} else {
+			inventory.Docker = version.Version
+		}
+	}
+	return inventory
+}
+
+func cpuModel() string {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.GOARCH
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		// x86 reports a model name, arm only a cpu part
+		switch strings.TrimSpace(key) {
+		case "model name", "Model", "Hardware":
+			return strings.TrimSpace(value)
+		}
+	}
+	return runtime.GOARCH
+}
+
+func memoryInventory() (memory out.MemoryInventory, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return memory, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kilobytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			memory.Total = kilobytes * 1024
+		case "MemAvailable:":
+			memory.Available = kilobytes * 1024
+		}
+	}
+	return memory, scanner.Err()
+}
+
+/*
+*
+capacity of every filesystem holding container data. the root filesystem stands in
+while nothing is hosted
+*/
+func (m *Machine) diskInventory() (disks []out.DiskInventory) {
+	paths := make(map[string][]string)
+	if m.Containers != nil {
+		for _, hosted := range m.Containers.Snapshot() {
+			if hosted.Path != "" {
+				paths[hosted.Path] = append(paths[hosted.Path], hosted.Id)
+			}
+		}
+	}
+	if len(paths) <= 0 {
+		paths["/"] = nil
+	}
+	byDevice := make(map[uint64]*out.DiskInventory)
+	for path, containers := range paths {
+		var stat syscall.Stat_t
+		if syscall.Stat(path, &stat) != nil {
+			continue
+		}
+		disk, ok := byDevice[stat.Dev]
+		if !ok {
+			var fs syscall.Statfs_t
+			if syscall.Statfs(path, &fs) != nil {
+				continue
+			}
+			disk = &out.DiskInventory{
+				Path:      path,
+				Total:     fs.Blocks * uint64(fs.Bsize),
+				Available: fs.Bavail * uint64(fs.Bsize),
+			}
+			byDevice[stat.Dev] = disk
+		}
+		disk.Containers = append(disk.Containers, containers...)
+	}
+	disks = make([]out.DiskInventory, 0, len(byDevice))
+	for _, disk := range byDevice {
+		sort.Strings(disk.Containers)
+		disks = append(disks, *disk)
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].Path < disks[j].Path
+	})
+	return disks
+}
+
+func kernelRelease() string {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(release))
+}
+
+func osRelease() string {
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return runtime.GOOS
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "PRETTY_NAME=")
+		if found {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return runtime.GOOS
+}
+
+/*
+*
+the version set at build time, or the vcs revision go embedded in the binary
+*/
+func supervisorVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "devel"
+}
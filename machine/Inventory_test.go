@@ -0,0 +1,54 @@
+package machine
+
+import (
+	"encoding/json"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/out"
+	"testing"
+	"time"
+)
+
+func TestInventoryChanged(t *testing.T) {
+	base := out.InventoryResponse{
+		Hostname:    "host",
+		Memory:      out.MemoryInventory{Total: 8 << 30, Available: 4<<30 + 100},
+		Disks:       []out.DiskInventory{{Path: "/", Total: 100 << 30, Available: 50<<30 + 100}},
+		CollectedAt: time.Now(),
+	}
+	jitter := base
+	jitter.CollectedAt = base.CollectedAt.Add(inventoryInterval)
+	jitter.Memory.Available += 4096
+	jitter.Disks = []out.DiskInventory{{Path: "/", Total: 100 << 30, Available: 50<<30 + 200}}
+	if inventoryChanged(&base, &jitter) {
+		t.Fatal("collection time and small capacity shifts counted as a change")
+	}
+	filled := base
+	filled.Disks = []out.DiskInventory{{Path: "/", Total: 100 << 30, Available: 40 << 30}}
+	if !inventoryChanged(&base, &filled) {
+		t.Fatal("a disk filling up wasn't counted as a change")
+	}
+	hosted := base
+	hosted.Disks = []out.DiskInventory{{Path: "/", Containers: []string{"c1"}, Total: 100 << 30, Available: 50<<30 + 100}}
+	if !inventoryChanged(&base, &hosted) {
+		t.Fatal("a newly hosted container wasn't counted as a change")
+	}
+	if base.Disks[0].Available != 50<<30+100 {
+		t.Fatal("comparing modified the inventory")
+	}
+}
+
+func TestPushInventory(t *testing.T) {
+	m := &Machine{events: event.NewQueue(eventLimit)}
+	m.pushInventory(&out.InventoryResponse{Hostname: "host"})
+	done := make(chan struct{})
+	close(done)
+	entry, ok := m.events.Pop(done)
+	if !ok || entry.Type != event.Inventory {
+		t.Fatalf("expected an inventory event, got %+v", entry)
+	}
+	inventory := out.InventoryResponse{}
+	err := json.Unmarshal([]byte(entry.Content), &inventory)
+	if err != nil || inventory.Hostname != "host" {
+		t.Fatalf("inventory didn't survive the queue: %+v, %v", inventory, err)
+	}
+}
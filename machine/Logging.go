@@ -0,0 +1,101 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/syslog"
+	"os"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/redact"
+)
+
+const syslogTag = "serverbench-supervisor"
+
+func init() {
+	// secrets are masked before any formatter or output sees a line
+	log.AddHook(redact.Hook{})
+}
+
+var (
+	UnknownLogFormatErr = errors.New("unknown log format (text, json)")
+)
+
+/*
+*
+applies level, format and output from the config to the standard logger. the output
+is stdout, stderr, syslog or a file path the logs are appended to
+*/
+func ConfigureLogging(config Config) (err error) {
+	if config.LogLevel != "" {
+		level, err := log.ParseLevel(config.LogLevel)
+		if err != nil {
+			return err
+		}
+		log.SetLevel(level)
+	}
+	switch config.LogFormat {
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return UnknownLogFormatErr
+	}
+	switch config.LogOutput {
+	case "", "stderr":
+		log.SetOutput(os.Stderr)
+	case "stdout":
+		log.SetOutput(os.Stdout)
+	case "syslog":
+		hook, err := lSyslog.NewSyslogHook("", "", syslog.LOG_INFO|syslog.LOG_DAEMON, syslogTag)
+		if err != nil {
+			return err
+		}
+		log.AddHook(hook)
+		log.SetOutput(io.Discard)
+	default:
+		file, err := os.OpenFile(config.LogOutput, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(file)
+	}
+	return nil
+}
+
+/*
+*
+fields identifying a request, attached to every log line written while it runs
+*/
+func requestFields(message in.Message) log.Fields {
+	fields := log.Fields{
+		"rid":     message.Rid,
+		"realm":   message.Realm,
+		"command": message.Command,
+	}
+	if message.Target != nil {
+		fields["container"] = *message.Target
+	}
+	return fields
+}
+
+/*
+*
+request fields with the id of the trace the request is recorded in, when tracing is on
+*/
+func tracedFields(ctx context.Context, message in.Message) log.Fields {
+	fields := requestFields(message)
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.HasTraceID() {
+		fields["trace_id"] = spanContext.TraceID().String()
+	}
+	return fields
+}
+
+func (m *Machine) requestLogger(message in.Message) (entry *log.Entry) {
+	return m.logger().WithFields(requestFields(message))
+}
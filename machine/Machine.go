@@ -1,11 +1,9 @@
 package machine
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
-	"flag"
 	"github.com/docker/docker/api/types"
 	dContainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
@@ -15,129 +13,152 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"supervisor/machine/container"
 	ip "supervisor/machine/container/ip"
 	"supervisor/machine/container/listener/event"
-	"supervisor/machine/proto/in"
-	"supervisor/machine/proto/out"
+	"supervisor/machine/tracing"
 	"sync"
+	"syscall"
 	"time"
 )
 
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
-	addr    = flag.String("addr", "hansel.serverbench.io", "http service address")
 	ctx     = context.Background()
 )
 
+const (
+	// pending log/alert entries kept per type and container while the backend falls behind
+	eventLimit          = 1024
+	queueReportInterval = 30 * time.Second
+	// runtime state, unlike /etc/serverbench nothing in it is configuration
+	stateDirectory = "/var/lib/serverbench"
+	// recently sent entries kept for replay, older ones are spilled to disk
+	journalCapacity   = 4096
+	journalPath       = stateDirectory + "/events.journal"
+	journalSpillLimit = 64 * 1024 * 1024
+	// time given to flush traces on exit, well within the unit's stop timeout
+	shutdownTimeout = 5 * time.Second
+	// command workers and how many commands may wait for one
+	commandWorkers = 8
+	commandBacklog = 256
+)
+
 type Machine struct {
-	Containers map[string]container.Container
-	events     chan event.Entry
+	Config     Config
+	Containers *container.Registry
+	events     *event.Queue
+	journal    *event.Journal
 	conn       *websocket.Conn
+	// bumped on every connection, replies to requests from an earlier one are dropped
+	generation uint64
 	cli        *client.Client
 	outMutex   sync.Mutex
+	sendMutex  sync.Mutex
+	health     Health
+	protocol   Protocol
+	traffic    Traffic
+	dispatcher *Dispatcher
+	inventory  Inventory
+	// flushes batched spans, called on the way out
+	shutdownTracing func(context.Context) error
+}
+
+/*
+*
+loads the docker state once and keeps the backend connection alive for as long
+as the supervisor runs. containers, their handlers and the docker listeners
+survive reconnects untouched
+*/
+func (m *Machine) Init() (err error) {
+	m.shutdownTracing, err = tracing.Configure(m.Config.TraceEndpoint, m.Config.TraceFile)
+	if err != nil {
+		m.logger().Error("unable to set up tracing: ", err)
+	}
+	go m.exitOnSignal()
+	m.restrictToken()
+	// install creates it, machines upgraded in place get it here
+	err = os.MkdirAll(stateDirectory, 0700)
+	if err != nil {
+		m.logger().Error("unable to create the state directory, the journal won't spill: ", err)
+	}
+	m.events = event.NewQueue(eventLimit)
+	// created once, the metrics endpoint reads it while docker is still loading
+	m.Containers = container.NewRegistry()
+	m.journal = event.NewJournal(journalCapacity, journalPath, journalSpillLimit)
+	m.dispatcher = NewDispatcher(commandWorkers, commandBacklog)
+	go m.reportQueue()
+	// before docker, containers found running start their load stream when metrics are on
+	err = m.serveMetrics()
+	if err != nil {
+		m.logger().Error("unable to start the metrics endpoint: ", err)
+	}
+	backoff := Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		err = m.initDocker()
+		if err == nil {
+			break
+		}
+		wait := backoff.Next()
+		m.logger().Error("unable to init docker, retrying in "+wait.String()+": ", err)
+		time.Sleep(wait)
+	}
+	go m.refreshInventory()
+	err = m.serveAdmin()
+	if err != nil {
+		m.logger().Error("unable to start the admin api: ", err)
+	}
+	m.connect()
+	return nil
 }
 
-func (m *Machine) Init(try int) (err error) {
-	// don't allow tries to go increase wait times infinitely
-	if try > 12 {
-		try -= 1
-	} else if try > 1 {
-		time.Sleep(time.Second * time.Duration(try*5))
+/*
+*
+systemd stops the supervisor with SIGTERM, spans still batched in memory are flushed
+before exiting
+*/
+func (m *Machine) exitOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	received := <-signals
+	m.logger().Info("received " + received.String() + ", shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := m.shutdownTracing(ctx)
+	if err != nil {
+		m.logger().Error("unable to flush traces: ", err)
 	}
-	m.events = make(chan event.Entry)
+	os.Exit(0)
+}
+
+func (m *Machine) initDocker() (err error) {
 	// init cli
 	m.cli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		m.logger().Error("unable to get docker client", err)
-		return m.Init(try + 1)
+		return err
 	}
 	// list local containers
 	err = m.loadContainersFromDocker()
 	if err != nil {
 		m.logger().Error("unable to list hosted containers locally")
-		return m.Init(try + 1)
+		return err
 	}
 	// events
 	err = m.listenForEvents()
 	if err != nil {
 		m.logger().Error("unable to start event listener", err)
-		return m.Init(try + 1)
-	}
-	// connect
-	params, err := m.getLoginString()
-	if err != nil {
-		m.logger().Error("unable to get login string", err)
-		return m.Init(try + 1)
-	}
-	u := url.URL{Scheme: "ws", Host: *addr, Path: "/machine"}
-	u.RawQuery = params.Encode()
-	m.logger().Info("connecting")
-	dial, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		m.logger().Error("unable to get dial socket", err)
-		return m.Init(try + 1)
-	}
-	m.logger().Info("connected")
-	try = 0
-	m.conn = dial
-	// handle events
-	go func() {
-		for str := range m.events {
-			m.outMutex.Lock()
-			err := m.conn.WriteJSON(str)
-			m.outMutex.Unlock()
-			if err != nil {
-				m.logger().Error("unable to forward event (socket likely closed)", err)
-				return
-			}
-		}
-	}()
-	for {
-		_, inBytes, err := m.conn.ReadMessage()
-		if err != nil {
-			m.logger().Error("unable to read message (socket likely closed)", err)
-			break
-		}
-		message := in.Message{}
-		err = json.Unmarshal(bytes.TrimSpace(bytes.Replace(inBytes, newline, space, -1)), &message)
-		if err != nil {
-			m.logger().Warn("malformed message received ("+string(inBytes)+")", err.Error())
-			continue
-		}
-		m.logger().Info("received request: %v", message)
-		reply, err := m.handleMessage(message)
-		m.outMutex.Lock()
-		err = m.conn.WriteJSON(out.Response{
-			Rid:   message.Rid,
-			Type:  "ack",
-			Error: err != nil,
-		})
-		m.outMutex.Unlock()
-		if err != nil {
-			m.logger().Warn("error while encoding ack: " + err.Error())
-			continue
-		}
-		if reply != nil {
-			m.outMutex.Lock()
-			err := m.conn.WriteJSON(reply)
-			m.outMutex.Unlock()
-			if err != nil {
-				m.logger().Warn("error while replying %s: %v", message.Rid, err)
-				continue
-			}
-		}
-		m.logger().Info("fulfilled request: %v", reply)
+		return err
 	}
-	close(m.events)
-	return m.Init(try + 1)
+	return nil
 }
 
 func (m *Machine) loadContainersFromDocker() (err error) {
-	m.Containers = make(map[string]container.Container)
+	m.Containers.Clear()
 	if m.cli == nil {
 		err = errors.New("invalid cli")
 		return err
@@ -148,19 +169,16 @@ func (m *Machine) loadContainersFromDocker() (err error) {
 	if err != nil {
 		return err
 	}
-	prefix := "/sb-"
 	for _, c := range dContainers {
 		for _, name := range c.Names {
-			if strings.HasPrefix(name, prefix) {
-				containerId := name[len(prefix):]
-				cont := container.Container{
-					Id: containerId,
-				}
-				err := cont.Init(&m.events, m.cli)
+			cont, ok := container.FromDockerName(name)
+			if ok {
+				containerId := cont.Id
+				err := cont.Init(m.events, m.cli)
 				if err != nil {
 					return err
 				}
-				m.Containers[containerId] = cont
+				m.Containers.Put(cont)
 				m.logger().Infof("loaded container %s", containerId)
 			}
 		}
@@ -196,7 +214,7 @@ func (m *Machine) listenForEvents() (err error) {
 					continue
 				}
 				containerId := name[3:]
-				localContainer, ok := m.Containers[containerId]
+				localContainer, ok := m.Containers.Get(containerId)
 				if !ok {
 					m.logger().Info("ignored non-serverbench container entry: ", entry)
 					continue
@@ -225,13 +243,7 @@ func (m *Machine) getLoginString() (params url.Values, err error) {
 		return nil, err
 	}
 
-	// 2. token
-	token, err := m.GetToken()
-	if err != nil {
-		return nil, err
-	}
-
-	// 3. networking
+	// 2. networking
 	var inets []ip.Ip // list of non-empty network interfaces
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -287,24 +299,58 @@ func (m *Machine) getLoginString() (params url.Values, err error) {
 	if err != nil {
 		return nil, err
 	}
-	containerIds := make([]string, len(m.Containers))
-	i := 0
-	for containerId, _ := range m.Containers {
-		containerIds[i] = containerId
-		i++
+	containerIds := m.Containers.Ids()
+	if containerIds == nil {
+		containerIds = make([]string, 0)
 	}
 	serializedContainers, err := json.Marshal(containerIds)
 	if err != nil {
 		return nil, err
 	}
 	params = url.Values{}
-	params.Set("token", *token)
 	params.Set("hostname", hostname)
 	params.Set("inets", string(serializedInets))
 	params.Set("containers", string(serializedContainers))
+	params.Set("epoch", m.journal.Epoch)
+	params.Set("sequence", strconv.FormatUint(m.journal.Sequence(), 10))
+	// fresh on every login, the backend places containers by it
+	inventory := m.collectInventory()
+	m.inventory.set(inventory)
+	serializedInventory, err := json.Marshal(inventory)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("inventory", string(serializedInventory))
 	return params, err
 }
 
+func (m *Machine) reportQueue() {
+	ticker := time.NewTicker(queueReportInterval)
+	for range ticker.C {
+		fields := log.Fields{}
+		for eventType, depth := range m.events.Depth() {
+			fields["depth_"+string(eventType)] = depth
+		}
+		for eventType, dropped := range m.events.Dropped() {
+			fields["dropped_"+string(eventType)] = dropped
+		}
+		health := m.health.Snapshot()
+		fields["connected"] = health.Connected
+		fields["last_pong"] = health.LastPong
+		fields["rtt"] = health.RoundTrip
+		// before compression, see Traffic
+		plain, encoded := m.traffic.Totals()
+		fields["bytes_plain"] = plain
+		fields["bytes_encoded"] = encoded
+		fields["bytes_saved_by_encoding"] = plain - encoded
+		fields["encoding"] = m.currentProtocol().Encoding
+		m.logger().WithFields(fields).Info("event queue")
+	}
+}
+
 func (m *Machine) logger() (entry *log.Entry) {
-	return log.WithFields(log.Fields{})
+	return log.WithFields(log.Fields{
+		"component": "machine",
+		"connected": m.health.Snapshot().Connected,
+	})
 }
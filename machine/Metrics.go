@@ -0,0 +1,83 @@
+package machine
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/metrics"
+	"time"
+)
+
+var eventTypes = []event.Type{event.Log, event.Status, event.Progress, event.Load, event.Alert, event.Inventory}
+
+var (
+	connectedDesc  = prometheus.NewDesc("serverbench_connected", "Whether the backend websocket is connected.", nil, nil)
+	reconnectsDesc = prometheus.NewDesc("serverbench_reconnects_total", "Backend connections lost since start.", nil, nil)
+	containersDesc = prometheus.NewDesc("serverbench_containers", "Hosted containers.", nil, nil)
+	queueDesc      = prometheus.NewDesc("serverbench_event_queue_depth", "Events waiting to be sent, by type.", []string{"type"}, nil)
+	droppedDesc    = prometheus.NewDesc("serverbench_events_dropped_total", "Events dropped while the backend fell behind, by type.", []string{"type"}, nil)
+)
+
+/*
+*
+reads connection, registry and queue state at scrape time
+*/
+type machineCollector struct {
+	m *Machine
+}
+
+func (c machineCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- connectedDesc
+	descs <- reconnectsDesc
+	descs <- containersDesc
+	descs <- queueDesc
+	descs <- droppedDesc
+}
+
+func (c machineCollector) Collect(collected chan<- prometheus.Metric) {
+	health := c.m.health.Snapshot()
+	connected := 0.0
+	if health.Connected {
+		connected = 1
+	}
+	collected <- prometheus.MustNewConstMetric(connectedDesc, prometheus.GaugeValue, connected)
+	collected <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, float64(health.Reconnects))
+	if c.m.Containers != nil {
+		collected <- prometheus.MustNewConstMetric(containersDesc, prometheus.GaugeValue, float64(c.m.Containers.Len()))
+	}
+	depth := c.m.events.Depth()
+	dropped := c.m.events.Dropped()
+	for _, eventType := range eventTypes {
+		collected <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(depth[eventType]), string(eventType))
+		collected <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(dropped[eventType]), string(eventType))
+	}
+}
+
+/*
+*
+serves the registry on the configured address, metrics stay off without one
+*/
+func (m *Machine) serveMetrics() (err error) {
+	if m.Config.MetricsAddress == "" {
+		return nil
+	}
+	err = metrics.Registry.Register(machineCollector{m: m})
+	if err != nil {
+		return err
+	}
+	metrics.Enable()
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              m.Config.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	m.logger().Info("metrics listening on " + m.Config.MetricsAddress)
+	go func() {
+		err := server.ListenAndServe()
+		m.logger().Error("metrics stopped: ", err)
+	}()
+	return nil
+}
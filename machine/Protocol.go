@@ -0,0 +1,246 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"strconv"
+	"strings"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+	"time"
+)
+
+const (
+	protocolHeader     = "X-Serverbench-Protocol"
+	capabilitiesHeader = "X-Serverbench-Capabilities"
+	ProtocolV1         = 1
+	ProtocolV2         = 2
+	// frame encodings
+	JsonEncoding    = "json"
+	MsgpackEncoding = "msgpack"
+)
+
+// everything this supervisor can do beyond the v1 basics, offered on login
+var Capabilities = []string{
+	"alerts",
+	"readiness",
+	"replay",
+	"signing",
+	"errors",
+	"heartbeat",
+	MsgpackEncoding,
+}
+
+/*
+*
+protocol agreed with the backend for the current connection. v1 sends data and
+event contents as encoded json strings, v2 embeds them as typed json
+*/
+type Protocol struct {
+	Version      int
+	Capabilities []string
+	Encoding     string
+}
+
+func offerProtocol() (versions string, capabilities string) {
+	versions = strconv.Itoa(ProtocolV1) + "," + strconv.Itoa(ProtocolV2)
+	return versions, strings.Join(Capabilities, ",")
+}
+
+/*
+*
+settles on the version picked by the backend (v1 for backends that don't
+negotiate) and the capabilities both sides support
+*/
+func negotiate(challenge in.ChallengeRequest) (protocol Protocol) {
+	protocol.Version = ProtocolV1
+	protocol.Encoding = JsonEncoding
+	if challenge.Protocol >= ProtocolV2 {
+		protocol.Version = ProtocolV2
+	}
+	protocol.Capabilities = make([]string, 0)
+	for _, requested := range challenge.Capabilities {
+		for _, supported := range Capabilities {
+			if requested == supported {
+				protocol.Capabilities = append(protocol.Capabilities, requested)
+				break
+			}
+		}
+	}
+	// binary frames are only used over typed v2 payloads
+	if protocol.Version >= ProtocolV2 && protocol.Supports(MsgpackEncoding) {
+		protocol.Encoding = MsgpackEncoding
+	}
+	return protocol
+}
+
+func (p *Protocol) Supports(capability string) bool {
+	for _, agreed := range p.Capabilities {
+		if agreed == capability {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+binary frame as sent by the backend. data is kept in its packed form, the signature
+covers those exact bytes
+*/
+type packedEnvelope struct {
+	Version   int                `msgpack:"v"`
+	Rid       string             `msgpack:"rid"`
+	Realm     string             `msgpack:"realm"`
+	Command   string             `msgpack:"command"`
+	Target    *string            `msgpack:"target,omitempty"`
+	Data      msgpack.RawMessage `msgpack:"data,omitempty"`
+	Timestamp int64              `msgpack:"timestamp,omitempty"`
+	Signature string             `msgpack:"signature,omitempty"`
+}
+
+/*
+*
+reads an incoming message, v2 envelopes are accepted on v2 connections and v1
+messages are always accepted. binary frames carry their data as json to the handlers
+*/
+func (p *Protocol) decode(messageType int, raw []byte) (message in.Message, err error) {
+	if messageType == websocket.BinaryMessage {
+		return unpack(raw)
+	}
+	if p.Version >= ProtocolV2 {
+		trimmed := bytes.TrimSpace(raw)
+		version := struct {
+			Version int `json:"v"`
+		}{}
+		err = json.Unmarshal(trimmed, &version)
+		if err != nil {
+			return message, err
+		}
+		if version.Version >= ProtocolV2 {
+			// untouched, the data bytes are what the signature covers
+			envelope := in.Envelope{}
+			err = json.Unmarshal(trimmed, &envelope)
+			if err != nil {
+				return message, err
+			}
+			return envelope.Message(), nil
+		}
+	}
+	raw = bytes.TrimSpace(bytes.Replace(raw, newline, space, -1))
+	err = json.Unmarshal(raw, &message)
+	return message, err
+}
+
+func unpack(raw []byte) (message in.Message, err error) {
+	envelope := packedEnvelope{}
+	err = msgpack.Unmarshal(raw, &envelope)
+	if err != nil {
+		return message, err
+	}
+	message = in.Message{
+		Rid:       envelope.Rid,
+		Realm:     envelope.Realm,
+		Command:   envelope.Command,
+		Target:    envelope.Target,
+		Timestamp: envelope.Timestamp,
+		Signature: envelope.Signature,
+	}
+	if len(envelope.Data) <= 0 {
+		return message, nil
+	}
+	var generic interface{}
+	err = msgpack.Unmarshal(envelope.Data, &generic)
+	if err != nil || generic == nil {
+		return message, err
+	}
+	message.SignedData = []byte(envelope.Data)
+	data, isString := generic.(string)
+	if envelope.Version >= ProtocolV2 || !isString {
+		encoded, err := json.Marshal(generic)
+		if err != nil {
+			return message, err
+		}
+		data = string(encoded)
+	}
+	message.Data = &data
+	return message, nil
+}
+
+/*
+*
+shapes an outgoing message for the connection version
+*/
+func (p *Protocol) encode(message interface{}) interface{} {
+	if p.Version < ProtocolV2 {
+		return message
+	}
+	switch typed := message.(type) {
+	case event.Entry:
+		return out.EventFromEntry(typed, p.Version)
+	case out.Response:
+		now := time.Now()
+		typed.Version = p.Version
+		typed.Timestamp = &now
+		return typed
+	case *out.Response:
+		return p.encode(*typed)
+	}
+	return message
+}
+
+/*
+*
+encodes an outgoing message into a websocket frame. plain is the json size of
+the message, used to measure what the binary encoding saves
+*/
+func (p *Protocol) frame(message interface{}) (messageType int, payload []byte, plain int, err error) {
+	payload, err = json.Marshal(p.encode(message))
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	plain = len(payload)
+	if p.Encoding != MsgpackEncoding {
+		return websocket.TextMessage, payload, plain, nil
+	}
+	// going through a generic value turns embedded json payloads into real maps
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	payload, err = msgpack.Marshal(numbers(generic))
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return websocket.BinaryMessage, payload, plain, nil
+}
+
+/*
+*
+replaces json numbers with ints where possible so they get packed compactly
+*/
+func numbers(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return integer
+		}
+		float, _ := typed.Float64()
+		return float
+	case map[string]interface{}:
+		for key, nested := range typed {
+			typed[key] = numbers(nested)
+		}
+	case []interface{}:
+		for i, nested := range typed {
+			typed[i] = numbers(nested)
+		}
+	}
+	return value
+}
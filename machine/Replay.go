@@ -0,0 +1,54 @@
+package machine
+
+import (
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/in"
+	"supervisor/machine/proto/out"
+)
+
+/*
+*
+records the entry in the journal and writes it to the backend. sequence numbers
+are assigned under the send lock so entries always leave in sequence order,
+entries that fail to write stay in the journal until they are replayed
+*/
+func (m *Machine) sendEvent(entry event.Entry) (err error) {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+	entry = m.journal.Append(entry)
+	return m.write(entry)
+}
+
+/*
+*
+resends every entry the backend missed since its last seen sequence, on login when
+the challenge carries it or later through the replay command. the journal only lives
+as long as the process, a different epoch means the backend last heard from an earlier
+process whose entries are gone, so everything journaled since this start is replayed
+*/
+func (m *Machine) replay(request in.ReplayRequest) (response *out.ReplayResponse, err error) {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+	since := request.Sequence
+	if request.Epoch != m.journal.Epoch {
+		since = 0
+	}
+	entries, complete, err := m.journal.Since(since)
+	if err != nil {
+		return nil, err
+	}
+	m.logger().Infof("replaying %d entries since %d", len(entries), since)
+	for _, entry := range entries {
+		err = m.write(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	response = &out.ReplayResponse{
+		Epoch:    m.journal.Epoch,
+		Replayed: len(entries),
+		Sequence: m.journal.Sequence(),
+		Complete: complete,
+	}
+	return response, nil
+}
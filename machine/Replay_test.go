@@ -0,0 +1,100 @@
+package machine
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/proto/in"
+	"testing"
+	"time"
+)
+
+/*
+*
+attaches a connection to a stand-in backend and returns the generation of the
+session along with every frame the backend receives, decoded as v1 json
+*/
+func backendSession(t *testing.T, m *Machine) (generation uint64, frames chan map[string]interface{}) {
+	frames = make(chan map[string]interface{}, 64)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, payload, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frame := make(map[string]interface{})
+			if json.Unmarshal(payload, &frame) == nil {
+				frames <- frame
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return m.attach(conn), frames
+}
+
+func nextFrame(t *testing.T, frames chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case frame := <-frames:
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("the backend received nothing")
+		return nil
+	}
+}
+
+func TestReplayFromTheBackendsSequence(t *testing.T) {
+	m := &Machine{journal: event.NewJournal(10, "", 0)}
+	_, frames := backendSession(t, m)
+	for i := 0; i < 3; i++ {
+		m.journal.Append(event.Entry{Type: event.Log, Container: "c1", Content: "line\n"})
+	}
+	cases := []struct {
+		name     string
+		request  in.ReplayRequest
+		replayed []float64
+	}{
+		{"same epoch", in.ReplayRequest{Epoch: m.journal.Epoch, Sequence: 1}, []float64{2, 3}},
+		{"up to date", in.ReplayRequest{Epoch: m.journal.Epoch, Sequence: 3}, nil},
+		// the sequence belongs to an earlier process, everything journaled since this start goes out
+		{"other epoch", in.ReplayRequest{Epoch: "earlier", Sequence: 2}, []float64{1, 2, 3}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response, err := m.replay(c.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Epoch != m.journal.Epoch || response.Sequence != 3 || !response.Complete || response.Replayed != len(c.replayed) {
+				t.Fatalf("unexpected replay response %+v", response)
+			}
+			for _, sequence := range c.replayed {
+				frame := nextFrame(t, frames)
+				if frame["sequence"] != sequence {
+					t.Fatalf("expected sequence %v, got %v", sequence, frame["sequence"])
+				}
+			}
+		})
+	}
+	select {
+	case frame := <-frames:
+		t.Fatalf("replayed more than asked for: %v", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
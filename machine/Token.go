@@ -1,75 +1,118 @@
 package machine
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"os"
+	"strings"
 )
 
+const (
+	tokenDirectory = "/etc/serverbench"
+	TokenPath      = tokenDirectory + "/supervisor.token"
+	// prefix of tokens encrypted with the machine key
+	sealedPrefix = "sealed:v1:"
+)
+
+var (
+	EmptyTokenErr       = errors.New("the token is empty")
+	MissingMachineIdErr = errors.New("no machine id to bind the token key to")
+	TokenKeyMismatchErr = errors.New("unable to decrypt the token, it was sealed on another machine")
+)
+
+var machineIdPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
 func (m *Machine) GetToken() (token *string, err error) {
 	m.logger().Info("reading token")
-	path, err := m.getTokenPath()
-	if err != nil {
-		return nil, err
-	}
-	tokenBytes, err := os.ReadFile(*path)
+	// stores written before tokens were root only are restricted once at startup, see restrictToken
+	tokenBytes, err := os.ReadFile(TokenPath)
 	if err != nil {
 		m.logger().Error("error reading token")
 		return nil, err
 	}
-	tokenVal := string(tokenBytes)
+	tokenVal := strings.TrimSpace(string(tokenBytes))
+	if strings.HasPrefix(tokenVal, sealedPrefix) {
+		tokenVal, err = unseal(tokenVal[len(sealedPrefix):])
+		if err != nil {
+			return nil, err
+		}
+	}
 	if len(tokenVal) <= 0 {
-		return nil, errors.New("the token is empty")
+		return nil, EmptyTokenErr
 	}
 	token = &tokenVal
 	m.logger().Info("token read")
 	return token, err
 }
 
-func (m *Machine) getTokenPath() (path *string, err error) {
-	// ensure the token path exists
-	m.logger().Info("accessing token store")
-	accPath := "/etc/serverbench"
-	err = os.MkdirAll(accPath, os.ModePerm)
-	if err != nil {
-		m.logger().Error("error while accessing/creating token store")
-		return nil, err
+/*
+*
+restricts a token store written before tokens were root only, machines upgraded in place
+would otherwise keep a readable token until install runs again
+*/
+func (m *Machine) restrictToken() {
+	info, err := os.Stat(TokenPath)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return
 	}
-	accPath += "/supervisor.token"
-	file, err := os.OpenFile(accPath, os.O_RDONLY|os.O_CREATE, 0666)
+	m.logger().Warn("token store is readable by other users (" + info.Mode().Perm().String() + "), restricting it to 0600")
+	err = os.Chmod(TokenPath, 0600)
 	if err != nil {
-		m.logger().Error("error touching token")
-		return nil, err
+		m.logger().Error("unable to restrict the token store: ", err)
 	}
-	err = file.Close()
-	if err != nil {
-		m.logger().Error("error detaching (touch) token")
-		return nil, err
-	}
-	m.logger().Info("exited token store")
-	path = &accPath
-	return path, err
 }
 
+/*
+*
+replaces the token through a rename, readers see either the old or the new token and
+never a partial write. the token is sealed with the machine key when the config asks for it
+*/
 func (m *Machine) UpdateToken(token string) (err error) {
+	token = strings.TrimSpace(token)
 	if len(token) <= 0 {
-		return errors.New("the token is empty")
+		return EmptyTokenErr
 	}
-	path, err := m.getTokenPath()
+	stored := token
+	if m.Config.SealToken {
+		sealed, err := seal(token)
+		if err != nil {
+			return err
+		}
+		stored = sealedPrefix + sealed
+	}
+	m.logger().Info("updating token")
+	err = os.MkdirAll(tokenDirectory, 0755)
 	if err != nil {
+		m.logger().Error("error while accessing/creating token store")
 		return err
 	}
-	m.logger().Info("updating token")
-	file, err := os.OpenFile(*path, os.O_WRONLY|os.O_TRUNC, 0644)
+	file, err := os.CreateTemp(tokenDirectory, ".supervisor.token.*")
 	if err != nil {
 		m.logger().Error("error while modifying token store")
 		return err
 	}
-	_, err = file.WriteString(token)
+	defer os.Remove(file.Name())
+	// CreateTemp already uses 0600, explicit in case the umask or filesystem says otherwise
+	err = file.Chmod(0600)
+	if err == nil {
+		_, err = file.WriteString(stored)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		m.logger().Error("error while writing into token store")
 		return err
 	}
-	err = file.Close()
+	err = os.Rename(file.Name(), TokenPath)
 	if err != nil {
 		m.logger().Error("error while exiting token store")
 		return err
@@ -77,3 +120,75 @@ func (m *Machine) UpdateToken(token string) (err error) {
 	m.logger().Info("updated token")
 	return err
 }
+
+/*
+*
+swaps in a token issued by the backend. the current connection stays authenticated
+with the old one, the new token is used from the next connect on. rotating to the
+current token succeeds, a retried rotation whose ack got lost is already applied
+*/
+func (m *Machine) RotateToken(token string) (err error) {
+	current, err := m.GetToken()
+	if err == nil && *current == strings.TrimSpace(token) {
+		m.logger().Info("token already rotated")
+		return nil
+	}
+	return m.UpdateToken(token)
+}
+
+/*
+*
+aes key derived from the machine id. a copied token store is useless on another machine,
+it doesn't protect against root on this one
+*/
+func machineKey() (key []byte, err error) {
+	for _, path := range machineIdPaths {
+		id, err := os.ReadFile(path)
+		if err == nil && len(strings.TrimSpace(string(id))) > 0 {
+			hash := sha256.Sum256([]byte("serverbench supervisor token|" + strings.TrimSpace(string(id))))
+			return hash[:], nil
+		}
+	}
+	return nil, MissingMachineIdErr
+}
+
+func tokenCipher() (aead cipher.AEAD, err error) {
+	key, err := machineKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(token string) (sealed string, err error) {
+	aead, err := tokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+func unseal(sealed string) (token string, err error) {
+	aead, err := tokenCipher()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", TokenKeyMismatchErr
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", TokenKeyMismatchErr
+	}
+	return string(plain), nil
+}
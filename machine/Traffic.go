@@ -0,0 +1,24 @@
+package machine
+
+import "sync/atomic"
+
+/*
+*
+outgoing byte counters, plain is what the messages would have taken as json and encoded
+what they took in the negotiated encoding. both are counted before per-message
+compression, which happens inside the websocket library, so they tell what the encoding
+saves and not what went over the wire
+*/
+type Traffic struct {
+	plain   atomic.Int64
+	encoded atomic.Int64
+}
+
+func (t *Traffic) record(plain int, encoded int) {
+	t.plain.Add(int64(plain))
+	t.encoded.Add(int64(encoded))
+}
+
+func (t *Traffic) Totals() (plain int64, encoded int64) {
+	return t.plain.Load(), t.encoded.Load()
+}
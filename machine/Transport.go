@@ -0,0 +1,105 @@
+package machine
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const handshakeTimeout = 30 * time.Second
+
+var (
+	PinMismatchErr = errors.New("backend certificate does not match any pinned key")
+)
+
+/*
+*
+tls settings shared by the websocket and the plain https calls to the backend
+*/
+func (c *Config) TlsConfig() (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.Insecure,
+	}
+	if c.CaFile != "" {
+		bundle, err := os.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("no certificates found in " + c.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(c.Pins) > 0 {
+		pins := make(map[string]bool, len(c.Pins))
+		for _, pin := range c.Pins {
+			pins[pin] = true
+		}
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, certificate := range state.PeerCertificates {
+				hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+				if pins[base64.StdEncoding.EncodeToString(hash[:])] {
+					return nil
+				}
+			}
+			return PinMismatchErr
+		}
+	}
+	return tlsConfig, nil
+}
+
+func (c *Config) Dialer() (dialer *websocket.Dialer, err error) {
+	tlsConfig, err := c.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: handshakeTimeout,
+		TLSClientConfig:  tlsConfig,
+		// per message deflate, used when the backend agrees to it
+		EnableCompression: !c.DisableCompression,
+	}
+	return dialer, nil
+}
+
+/*
+*
+the https counterpart of the websocket endpoint, path is joined onto the endpoint path
+*/
+func (c *Config) ApiUrl(path ...string) (api *url.URL, err error) {
+	api, err = c.EndpointUrl()
+	if err != nil {
+		return nil, err
+	}
+	if api.Scheme == "ws" {
+		api.Scheme = "http"
+	} else {
+		api.Scheme = "https"
+	}
+	api.RawQuery = ""
+	return api.JoinPath(path...), nil
+}
+
+func (c *Config) HttpClient() (httpClient *http.Client, err error) {
+	tlsConfig, err := c.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: handshakeTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
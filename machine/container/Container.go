@@ -2,7 +2,6 @@ package container
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -21,19 +20,26 @@ import (
 	"supervisor/machine/container/listener"
 	"supervisor/machine/container/listener/activity"
 	"supervisor/machine/container/listener/event"
+	"supervisor/machine/tracing"
+	"sync"
 )
 
 type Container struct {
-	Id         string            `json:"id"`
-	Template   HostingTemplate   `json:"template"`
-	Ports      []ip.Port         `json:"ports"`
-	Envs       map[string]string `json:"envs"`
-	Path       string            `json:"path"`
-	Memory     int               `json:"memory"`
-	Storage    *int              `json:"storage"`
-	Repository *Repository       `json:"repository,omitempty"`
-	Branch     *string           `json:"branch,omitempty"`
+	Id         string             `json:"id"`
+	Template   HostingTemplate    `json:"template"`
+	Ports      []ip.Port          `json:"ports"`
+	Envs       map[string]string  `json:"envs"`
+	Path       string             `json:"path"`
+	Memory     int                `json:"memory"`
+	Storage    *int               `json:"storage"`
+	Repository *Repository        `json:"repository,omitempty"`
+	Branch     *string            `json:"branch,omitempty"`
+	Watchers   []listener.Watcher `json:"watchers,omitempty"`
 	Handler    *listener.Handler
+	// request running on the container. only queued commands trace, and those never overlap
+	trace        log.Fields
+	traceContext context.Context
+	traceMutex   sync.Mutex
 }
 
 var (
@@ -41,9 +47,12 @@ var (
 	directory = "/etc/serverbench/containers/"
 )
 
-func (c *Container) Init(out *chan event.Entry, cli *client.Client) (err error) {
+// docker containers and system users of hosted containers are named sb-<id>
+const namePrefix = "sb-"
+
+func (c *Container) Init(out *event.Queue, cli *client.Client) (err error) {
 	if c.Handler != nil {
-		return errors.New("container already initialized")
+		return AlreadyInitializedErr
 	}
 	handler := listener.Handler{
 		Status:        make([]listener.Subscriber, 0),
@@ -56,9 +65,28 @@ func (c *Container) Init(out *chan event.Entry, cli *client.Client) (err error)
 		ProgressCache: make(map[string]event.ProgressUpdate),
 	}
 	err = handler.Forward(out)
-	if err == nil {
-		c.Handler = &handler
+	if err != nil {
+		return err
+	}
+	spec := c.watchSpec()
+	if spec.empty() {
+		// loaded back from docker, the spec lives on the docker container
+		spec = c.storedWatchSpec(cli)
 	}
+	for _, watcher := range spec.Watchers {
+		err = handler.AddWatcher(watcher)
+		if err != nil {
+			c.logger().Error("invalid watcher "+watcher.Id+": ", err)
+			return err
+		}
+	}
+	err = handler.SetReadiness(spec.Readiness)
+	if err != nil {
+		c.logger().Error("invalid readiness: ", err)
+		return err
+	}
+	handler.Resume()
+	c.Handler = &handler
 	return err
 }
 
@@ -76,12 +104,12 @@ func (c *Container) isGitRepository() (isRepo bool, err error) {
 }
 
 func (c *Container) getState(cli *client.Client) (state *types.ContainerState, err error) {
-	inspect, err := cli.ContainerInspect(context.Background(), c.Username())
+	inspect, err := cli.ContainerInspect(c.context(), c.Username())
 	if err != nil {
 		return state, err
 	}
 	if inspect.State == nil {
-		err = errors.New("no container state")
+		err = MissingStateErr
 		return state, err
 	}
 	return inspect.State, err
@@ -93,10 +121,44 @@ func (c *Container) Stop(cli *client.Client) (err error) {
 		return err
 	}
 	if state.Paused {
-		err = errors.New("container is frozen")
+		err = FrozenErr
+		return err
+	}
+	err = cli.ContainerStop(c.context(), c.Username(), container.StopOptions{})
+	return err
+}
+
+/*
+*
+starts the existing docker container as it was created, unlike Start which recreates it
+from the hosting definition
+*/
+func (c *Container) PowerOn(cli *client.Client) (err error) {
+	state, err := c.getState(cli)
+	if err != nil {
+		return err
+	}
+	if state.Paused {
+		err = FrozenErr
 		return err
 	}
-	err = cli.ContainerStop(context.Background(), c.Username(), container.StopOptions{})
+	if state.Running {
+		return nil
+	}
+	err = cli.ContainerStart(c.context(), c.Username(), container.StartOptions{})
+	return err
+}
+
+func (c *Container) Restart(cli *client.Client) (err error) {
+	state, err := c.getState(cli)
+	if err != nil {
+		return err
+	}
+	if state.Paused {
+		err = FrozenErr
+		return err
+	}
+	err = cli.ContainerRestart(c.context(), c.Username(), container.StopOptions{})
 	return err
 }
 
@@ -104,17 +166,17 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 	c.logger().Info("pulling repository")
 	if c.Branch == nil {
 		c.logger().Error("missing repository branch")
-		err = errors.New("pull needs a branch")
+		err = MissingBranchErr
 		return err
 	}
 	if c.Repository == nil {
 		c.logger().Error("missing repository uri")
-		err = errors.New("pull needs a repository")
+		err = MissingRepositoryErr
 		return err
 	}
 	if token == nil {
 		c.logger().Error("missing repository token")
-		err = errors.New("pull needs a token")
+		err = MissingGitTokenErr
 		return err
 	}
 	// check username and token
@@ -138,7 +200,7 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 	shouldRestart := false
 	if state.Paused {
 		c.logger().Error("unable tu pull while frozen")
-		err = errors.New("unable to perform pull while the container is frozen")
+		err = FrozenErr
 		return err
 	} else if state.Running {
 		c.logger().Info("stopping container in preparation for pull - container will be restarted when finished")
@@ -168,6 +230,7 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 			DescriptionIndex: 1,
 			HeadSha:          headSha,
 			Type:             "git",
+			Context:          c.context(),
 		}
 		err = cloneActivity.Exec(c.Handler)
 		if err != nil {
@@ -183,19 +246,19 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 	}
 	// clean repo
 	c.logger().Info("whitelisting repo")
-	err = exec.Command("git", "config", "--global", "--add", "safe.directory", dataPath).Run()
+	err = tracing.Run(c.context(), exec.Command("git", "config", "--global", "--add", "safe.directory", dataPath))
 	if err != nil {
 		c.logger().Error("error while whitelisting repo")
 		return err
 	}
 	c.logger().Info("resetting repo")
-	err = exec.Command("git", "-C", dataPath, "reset", "--hard").Run()
+	err = tracing.Run(c.context(), exec.Command("git", "-C", dataPath, "reset", "--hard"))
 	if err != nil {
 		c.logger().Error("error resetting repo: ", err)
 		return err
 	}
 	c.logger().Info("cleaning up repo")
-	err = exec.Command("git", "-C", dataPath, "clean", "-dff").Run()
+	err = tracing.Run(c.context(), exec.Command("git", "-C", dataPath, "clean", "-dff"))
 	if err != nil {
 		c.logger().Error("error cleaning up repo: ", err)
 		return err
@@ -203,14 +266,14 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 	if !isUpdated {
 		// update remote url (token)
 		c.logger().Info("updating remote")
-		err = exec.Command("git", "-C", dataPath, "remote", "set-url", "origin", gitUrl).Run()
+		err = tracing.Run(c.context(), exec.Command("git", "-C", dataPath, "remote", "set-url", "origin", gitUrl))
 		if err != nil {
 			c.logger().Error("error while updating remote")
 			return err
 		}
 		// ensure correct branch
 		c.logger().Info("checking out branch")
-		err = exec.Command("git", "-C", dataPath, "checkout", *c.Branch).Run()
+		err = tracing.Run(c.context(), exec.Command("git", "-C", dataPath, "checkout", *c.Branch))
 		if err != nil {
 			c.logger().Error("error while checking out branch: ", err)
 			return err
@@ -226,6 +289,7 @@ func (c *Container) Pull(cli *client.Client, token *string, headSha *string) (er
 			DescriptionIndex: 1,
 			HeadSha:          headSha,
 			Type:             "git",
+			Context:          c.context(),
 		}
 		err = pullActivity.Exec(c.Handler)
 		if err != nil {
@@ -249,7 +313,7 @@ func (c *Container) pullAside() (temporaryId string, err error) {
 		return "", err
 	}
 	originPath := path.Join(c.Path, "data")
-	r, err := exec.Command("rsync", "-a", "--remove-source-files", c.appendSlash(originPath), targetPath).Output()
+	r, err := tracing.Output(c.context(), exec.Command("rsync", "-a", "--remove-source-files", c.appendSlash(originPath), targetPath))
 	if err != nil {
 		c.logger().Error("error while pulling aside, trying to bring together: ", string(r), ", ", err)
 		_ = c.bringTogether(temporaryId)
@@ -262,7 +326,7 @@ func (c *Container) bringTogether(temporaryId string) (err error) {
 	c.logger().Info("bringing together aside")
 	temporaryDirectory := path.Join(c.Path, "data-"+temporaryId)
 	originPath := path.Join(c.Path, "data")
-	r, err := exec.Command("rsync", "-a", "--remove-source-files", "--ignore-existing", c.appendSlash(temporaryDirectory), originPath).Output()
+	r, err := tracing.Output(c.context(), exec.Command("rsync", "-a", "--remove-source-files", "--ignore-existing", c.appendSlash(temporaryDirectory), originPath))
 	if err != nil {
 		c.logger().Error("error while bringing together: ", string(r), ", ", err)
 		return err
@@ -324,6 +388,7 @@ func (c *Container) Transfer(out bool, externalAddress string, externalPort int,
 		ProgressRegex:    activity.GenericPercentRegex,
 		HeadSha:          headSha,
 		Type:             "transfer",
+		Context:          c.context(),
 	}
 	err = transferActivity.Exec(c.Handler)
 	if err != nil {
@@ -333,7 +398,7 @@ func (c *Container) Transfer(out bool, externalAddress string, externalPort int,
 	return nil
 }
 
-func (c *Container) Host(cli *client.Client, containers map[string]Container, token *string, headSha *string) (err error) {
+func (c *Container) Host(cli *client.Client, containers *Registry, token *string, headSha *string) (err error) {
 	c.logger().Info("hosting")
 	exists, err := c.userExists()
 	if err != nil {
@@ -345,7 +410,7 @@ func (c *Container) Host(cli *client.Client, containers map[string]Container, to
 			return err
 		}
 	}
-	containers[c.Id] = *c
+	containers.Put(c)
 
 	err = c.ApplyRules()
 	if err != nil {
@@ -353,14 +418,15 @@ func (c *Container) Host(cli *client.Client, containers map[string]Container, to
 	}
 
 	// container should mount volume onto settings.path/data
-	go func() {
-		_ = c.Start(cli, token, headSha)
-	}()
+	err = c.Start(cli, token, headSha)
+	if err != nil {
+		return err
+	}
 	c.logger().Info("hosted " + c.Id)
 	return nil
 }
 
-func (c *Container) Unhost(cli *client.Client, containers map[string]Container) (err error) {
+func (c *Container) Unhost(cli *client.Client, containers *Registry) (err error) {
 	c.logger().Info("unhosting " + c.Id)
 	_ = c.Kill(cli)
 	err = c.deleteChain()
@@ -368,14 +434,14 @@ func (c *Container) Unhost(cli *client.Client, containers map[string]Container)
 	if err != nil {
 		return err
 	}
-	delete(containers, c.Id)
+	containers.Remove(c.Id)
 	c.logger().Info("unhosted " + c.Id)
 	return nil
 }
 
 func (c *Container) Kill(cli *client.Client) (err error) {
 	c.logger().Info("killing")
-	err = cli.ContainerRemove(context.Background(), c.Username(), container.RemoveOptions{
+	err = cli.ContainerRemove(c.context(), c.Username(), container.RemoveOptions{
 		Force: true,
 	})
 	if err != nil {
@@ -387,13 +453,42 @@ func (c *Container) Kill(cli *client.Client) (err error) {
 }
 
 func (c *Container) logger() (entry *log.Entry) {
-	return log.WithFields(log.Fields{
-		"container": c.Id,
-	})
+	c.traceMutex.Lock()
+	defer c.traceMutex.Unlock()
+	return log.WithFields(c.trace).WithField("container", c.Id)
+}
+
+/*
+*
+attaches the request fields to every log line the container writes, and parents docker
+calls, host commands and activities to the request span, until the returned func puts
+back whatever was traced before
+*/
+func (c *Container) Trace(ctx context.Context, fields log.Fields) (untrace func()) {
+	c.traceMutex.Lock()
+	defer c.traceMutex.Unlock()
+	previous, previousContext := c.trace, c.traceContext
+	c.trace = fields
+	c.traceContext = ctx
+	return func() {
+		c.traceMutex.Lock()
+		defer c.traceMutex.Unlock()
+		c.trace = previous
+		c.traceContext = previousContext
+	}
+}
+
+func (c *Container) context() context.Context {
+	c.traceMutex.Lock()
+	defer c.traceMutex.Unlock()
+	if c.traceContext == nil {
+		return context.Background()
+	}
+	return c.traceContext
 }
 
 func (c *Container) containerExists(cli *client.Client) (exists bool, err error) {
-	list, err := cli.ContainerList(context.Background(), container.ListOptions{
+	list, err := cli.ContainerList(c.context(), container.ListOptions{
 		All: true,
 	})
 	if err != nil {
@@ -407,8 +502,13 @@ func (c *Container) containerExists(cli *client.Client) (exists bool, err error)
 	return false, nil
 }
 
+/*
+*
+(re)creates the docker container from the hosting definition and starts it, an existing
+container is removed first
+*/
 func (c *Container) Start(cli *client.Client, token *string, headSha *string) (err error) {
-	ctx := context.Background()
+	ctx := c.context()
 	exists, err := c.containerExists(cli)
 	if err != nil {
 		return err
@@ -418,7 +518,7 @@ func (c *Container) Start(cli *client.Client, token *string, headSha *string) (e
 		if err != nil {
 			return err
 		}
-		err = cli.ContainerRemove(context.Background(), c.Username(), container.RemoveOptions{
+		err = cli.ContainerRemove(c.context(), c.Username(), container.RemoveOptions{
 			Force: true,
 		})
 		if err != nil {
@@ -435,11 +535,16 @@ func (c *Container) Start(cli *client.Client, token *string, headSha *string) (e
 	for k, v := range c.Envs {
 		parsedEnvs = append(parsedEnvs, fmt.Sprintf("%s=%s", k, v))
 	}
+	labels, err := c.watchLabels()
+	if err != nil {
+		return err
+	}
 	config := &container.Config{
 		Image:     c.Template.Image.Uri,
 		Env:       parsedEnvs,
 		Tty:       true,
 		OpenStdin: true,
+		Labels:    labels,
 	}
 	portBindings := nat.PortMap{}
 	for _, port := range c.Ports {
@@ -486,5 +591,32 @@ func (c *Container) Start(cli *client.Client, token *string, headSha *string) (e
 }
 
 func (c *Container) Username() (username string) {
-	return "sb-" + c.Id
+	return namePrefix + c.Id
+}
+
+/*
+*
+the hosted container behind a docker container name, as the supervisor loads it on
+startup. names without the sb- prefix don't belong to hosted containers. its watchers
+and readiness are read back from the docker container in Init
+*/
+func FromDockerName(name string) (c *Container, ok bool) {
+	id, ok := strings.CutPrefix(strings.TrimPrefix(name, "/"), namePrefix)
+	if !ok || id == "" {
+		return nil, false
+	}
+	return &Container{Id: id}, true
+}
+
+/*
+*
+finds a hosted container in docker, for acting on it while the supervisor is down
+*/
+func Lookup(cli *client.Client, id string) (c *Container, err error) {
+	c = &Container{Id: id}
+	_, err = c.getState(cli)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
@@ -0,0 +1,17 @@
+package container
+
+import "errors"
+
+var (
+	AlreadyInitializedErr = errors.New("container already initialized")
+	MissingStateErr       = errors.New("no container state")
+	FrozenErr             = errors.New("container is frozen")
+	MissingBranchErr      = errors.New("pull needs a branch")
+	MissingRepositoryErr  = errors.New("pull needs a repository")
+	MissingGitTokenErr    = errors.New("pull needs a token")
+	InvalidGitTokenErr    = errors.New("invalid git token")
+	RootUnsupportedErr    = errors.New("root unsupported")
+	KeyTooLongErr         = errors.New("key too long")
+	InvalidKeyErr         = errors.New("invalid key")
+	KeyNotFoundErr        = errors.New("authorized key not found")
+)
@@ -1,8 +1,12 @@
 package container
 
+import "supervisor/machine/container/listener"
+
 type HostingTemplate struct {
 	Id        string                    `json:"id"`
 	Image     HostingImage              `json:"image"`
 	Name      *string                   `json:"name"`
 	Variables []HostingTemplateVariable `json:"variables"`
+	Watchers  []listener.Watcher        `json:"watchers,omitempty"`
+	Readiness *listener.Readiness       `json:"readiness,omitempty"`
 }
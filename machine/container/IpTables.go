@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os/exec"
 	"strconv"
+	"supervisor/machine/tracing"
 )
 
 const pre = "sb"
@@ -13,13 +14,13 @@ func (c *Container) ChainName() string {
 }
 
 func (c *Container) flushChain() (err error) {
-	return exec.Command("iptables", "-F", c.ChainName()).Run()
+	return tracing.Run(c.context(), exec.Command("iptables", "-F", c.ChainName()))
 }
 
 func (c *Container) deleteChain() (err error) {
 	c.logger().Info("deleting chain")
 	err = c.flushChain()
-	err = exec.Command("iptables", "-X", c.ChainName()).Run()
+	err = tracing.Run(c.context(), exec.Command("iptables", "-X", c.ChainName()))
 	if err != nil {
 		c.logger().Error("error while deleting chain: ", err)
 		return err
@@ -43,7 +44,7 @@ func (c *Container) ApplyRules() (err error) {
 	}
 	c.logger().Info("applying new rules")
 	for _, port := range c.Ports {
-		err = port.CreateRules(c.ChainName())
+		err = port.CreateRules(c.context(), c.ChainName())
 		if err != nil {
 			c.logger().Error("error while creating rules for "+strconv.Itoa(port.Port)+": ", err)
 			return err
@@ -68,11 +69,11 @@ func (c *Container) createChainIfNeeded() (err error) {
 		missingChain = false
 	}
 	if missingChain {
-		err = exec.Command("iptables", "-N", c.ChainName()).Run()
+		err = tracing.Run(c.context(), exec.Command("iptables", "-N", c.ChainName()))
 		if err != nil {
 			return err
 		}
-		err = exec.Command("iptables", "-I", "FORWARD", "-j", c.ChainName()).Run()
+		err = tracing.Run(c.context(), exec.Command("iptables", "-I", "FORWARD", "-j", c.ChainName()))
 		if err != nil {
 			return err
 		}
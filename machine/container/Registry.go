@@ -0,0 +1,86 @@
+package container
+
+import (
+	"sort"
+	"supervisor/machine/metrics"
+	"sync"
+)
+
+/*
+*
+hosted containers by id. entries are pointers, so every goroutine works on the
+same container (and handler) instead of diverging copies
+*/
+type Registry struct {
+	mutex      sync.RWMutex
+	containers map[string]*Container
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		containers: make(map[string]*Container),
+	}
+}
+
+func (r *Registry) Get(id string) (c *Container, ok bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	c, ok = r.containers[id]
+	return c, ok
+}
+
+func (r *Registry) Put(c *Container) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.containers[c.Id] = c
+}
+
+func (r *Registry) Remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.containers, id)
+	metrics.Forget(id)
+}
+
+/*
+*
+removes every container, the registry itself stays in place for its readers
+*/
+func (r *Registry) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id := range r.containers {
+		delete(r.containers, id)
+		metrics.Forget(id)
+	}
+}
+
+/*
+*
+the containers present at the time of the call, sorted by id
+*/
+func (r *Registry) Snapshot() (containers []*Container) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	containers = make([]*Container, 0, len(r.containers))
+	for _, c := range r.containers {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Id < containers[j].Id
+	})
+	return containers
+}
+
+func (r *Registry) Ids() (ids []string) {
+	for _, c := range r.Snapshot() {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
+
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.containers)
+}
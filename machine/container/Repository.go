@@ -1,7 +1,6 @@
 package container
 
 import (
-	"errors"
 	"regexp"
 )
 
@@ -16,7 +15,7 @@ func (r *Repository) CheckToken(token string) (err error) {
 	}
 	match := re.MatchString(token)
 	if match == false {
-		err = errors.New("invalid git token")
+		err = InvalidGitTokenErr
 	}
 	return err
 }
@@ -13,8 +13,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"supervisor/machine/tracing"
 )
 
+const SshdConfig = "/etc/ssh/sshd_config"
+
 func (c *Container) setupDirectory(directory string) (err error) {
 	c.logger().Info("creating sshd directory")
 	return os.MkdirAll(directory, os.ModePerm)
@@ -22,40 +25,35 @@ func (c *Container) setupDirectory(directory string) (err error) {
 
 func (c *Container) setupGroup(group string) (err error) {
 	c.logger().Info("creating sshd group")
-	output, err := exec.Command("groupadd", "-f", group).Output()
+	output, err := tracing.Output(c.context(), exec.Command("groupadd", "-f", group))
 	if err != nil {
 		c.logger().Error("sshd group creation error: " + string(output))
 	}
 	return err
 }
 
-func (c *Container) commentOut(original string) (output string) {
-	c.logger().Info("commenting out: " + original)
+func commentOut(original string) (output string) {
 	return "# before serverbench: " + original + "\n"
 }
 
-func (c *Container) setupSshdJail(group string, directory string) (err error) {
-	c.logger().Info("setting up sshd")
-	sshdConfig := "/etc/ssh/sshd_config"
-	file, err := os.OpenFile(sshdConfig, os.O_RDWR, 0644)
-	if err != nil {
-		c.logger().Error("unable to read ftp jail")
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	output := ""
+/*
+*
+the sshd config with sftp served internally and members of the hosting group jailed into
+their home directory. updated is false when the config already is
+*/
+func SshdJail(original string) (config string, updated bool) {
+	return jailConfig(original, group, directory)
+}
 
-	updated := false
+func jailConfig(original string, group string, directory string) (output string, updated bool) {
+	scanner := bufio.NewScanner(strings.NewReader(original))
 	foundGroupMatching := false
 	foundSubsystem := false
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "Subsystem") {
 			if !strings.HasSuffix(line, "internal-sftp") {
-				c.logger().Info("updating sshd subsystem")
-				output += c.commentOut(line)
+				output += commentOut(line)
 				line = "Subsystem\tsftp\tinternal-sftp"
 				updated = true
 			}
@@ -73,27 +71,42 @@ func (c *Container) setupSshdJail(group string, directory string) (err error) {
 	}
 
 	if !foundSubsystem {
-		c.logger().Info("appending sshd subsystem")
 		output += "Subsystem\tsftp\tinternal-sftp\n"
 	}
 
 	if !foundGroupMatching {
-		c.logger().Info("appending sshd group match rule")
 		output += "Match Group " + group + "\n"
 		output += "  ForceCommand internal-sftp -d /data\n"
 		output += "  ChrootDirectory " + directory + "%u\n"
 	}
+	return output, updated
+}
 
+func (c *Container) setupSshdJail(group string, directory string) (err error) {
+	c.logger().Info("setting up sshd")
+	original, err := os.ReadFile(SshdConfig)
+	if err != nil {
+		c.logger().Error("unable to read ftp jail")
+		return err
+	}
+	output, updated := jailConfig(string(original), group, directory)
 	if updated {
 		c.logger().Info("saving new sshd config")
-		overwrite, err := os.Create(sshdConfig)
+		err = os.WriteFile(SshdConfig, []byte(output), 0644)
 		if err != nil {
 			c.logger().Error("unable to save new sshd config")
 			return err
 		}
-		_, err = overwrite.WriteString(output)
 		c.logger().Info("restarting sshd")
-		exec.Command("systemctl", "restart", "ssh.service")
+		// debian names the unit ssh, most other distributions sshd
+		err = tracing.Run(c.context(), exec.Command("systemctl", "restart", "ssh.service"))
+		if err != nil {
+			err = tracing.Run(c.context(), exec.Command("systemctl", "restart", "sshd.service"))
+		}
+		if err != nil {
+			c.logger().Error("unable to restart sshd, the sftp jail won't apply until it restarts")
+			return err
+		}
 	}
 	return err
 }
@@ -119,7 +132,7 @@ func (c *Container) setupMount(home string, username string, group string) (err
 	c.logger().Info("created data mounting directory")
 
 	// create mount
-	output, err := exec.Command("mount", "--bind", targetData, homeData).Output()
+	output, err := tracing.Output(c.context(), exec.Command("mount", "--bind", targetData, homeData))
 	if err != nil {
 		c.logger().Error("error while mounting data: " + string(output))
 		return err
@@ -145,7 +158,7 @@ func (c *Container) setupMount(home string, username string, group string) (err
 }
 
 func (c *Container) setChown(username string, path string, group string) (err error) {
-	output, err := exec.Command("chown", "-R", username+":"+group, path).Output()
+	output, err := tracing.Output(c.context(), exec.Command("chown", "-R", username+":"+group, path))
 	if err != nil {
 		c.logger().Error("error while chowning (target jailing): " + string(output))
 	}
@@ -159,7 +172,7 @@ func (c *Container) setJail(path string) (err error) {
 		c.logger().Error("error while chowning (jailing) " + path + ": " + err.Error())
 		return err
 	}
-	_, err = exec.Command("chmod", "755", path).Output()
+	_, err = tracing.Output(c.context(), exec.Command("chmod", "755", path))
 	if err != nil {
 		c.logger().Error("error while chmodding (jailing) " + path + ": " + err.Error())
 	}
@@ -172,19 +185,19 @@ func (c *Container) ResetPassword() (pswd *string, err error) {
 	c.logger().Info("resetting " + username + " password")
 	if username == "root" {
 		c.logger().Error("protected root password password")
-		return nil, errors.New("root unsupported")
+		return nil, RootUnsupportedErr
 	}
 	pwd, err := password.Generate(32, 10, 0, false, false)
 	if err != nil {
 		return nil, err
 	}
 	pswd = &pwd
-	output, err := exec.Command("bash", "-c", "echo \""+username+":"+pwd+"\" | /usr/sbin/chpasswd").Output()
+	output, err := tracing.Output(c.context(), exec.Command("bash", "-c", "echo \""+username+":"+pwd+"\" | /usr/sbin/chpasswd"))
 	if err != nil {
 		c.logger().Error("password reset for " + username + " error, output was: " + string(output))
 		return nil, err
 	}
-	c.logger().Info("reset " + username + " password: sftp://" + username + ":" + *pswd + "@ip")
+	c.logger().Info("reset " + username + " password")
 	return pswd, nil
 }
 
@@ -235,7 +248,7 @@ func (c *Container) ResetKeys() (publicKey string, err error) {
 		return publicKey, err
 	}
 
-	err = exec.Command("sh", "-c", `yes y | ssh-keygen -t ed25519 -C "<id>" -f `+path.Join(sshDir, "id_ed25519")+` -N ""`).Run()
+	err = tracing.Run(c.context(), exec.Command("sh", "-c", `yes y | ssh-keygen -t ed25519 -C "<id>" -f `+path.Join(sshDir, "id_ed25519")+` -N ""`))
 	if err != nil {
 		c.logger().Error("error while creating ssh key")
 		return publicKey, err
@@ -312,7 +325,7 @@ func (c *Container) RemoveAuthorizedKey(authorizedKey string) (err error) {
 		}
 	}
 	if !found {
-		err = errors.New("authorized key not found")
+		return KeyNotFoundErr
 	}
 	err = os.WriteFile(authKeysFile, []byte(strings.Join(output, "\n")), 0600)
 	if err != nil {
@@ -353,13 +366,13 @@ func (c *Container) getPrivateKeyFile() string {
 
 func (c *Container) checkKey(key string) (err error) {
 	if len(key) > 8192 {
-		err = errors.New("key too long")
+		err = KeyTooLongErr
 		return err
 	}
 	sshKeyRegex := regexp.MustCompile(`^ssh-(rsa|ed25519|dsa|ecdsa)\s+[A-Za-z0-9+/]+[=]{0,2}\s*[^\s@]+(?:@\S+)?$`)
 	matches := sshKeyRegex.MatchString(key)
 	if matches == false {
-		err = errors.New("invalid key")
+		err = InvalidKeyErr
 	}
 	return err
 }
@@ -380,7 +393,7 @@ func (c *Container) createUser() (pswd *string, err error) {
 	c.logger().Info("creating user " + username + " (" + c.Path + ")")
 	if username == "root" {
 		c.logger().Error("protected root creation")
-		return nil, errors.New("root unsupported")
+		return nil, RootUnsupportedErr
 	}
 
 	err = c.setupGroup(group)
@@ -399,7 +412,7 @@ func (c *Container) createUser() (pswd *string, err error) {
 	}
 
 	home := filepath.Join(directory, username)
-	output, err := exec.Command("/usr/sbin/useradd", "-m", "-d", home, "-G", group, "--shell", "/bin/false", username).Output()
+	output, err := tracing.Output(c.context(), exec.Command("/usr/sbin/useradd", "-m", "-d", home, "-G", group, "--shell", "/bin/false", username))
 	if err != nil {
 		c.logger().Error("ssh user creation error for #" + username + ", output was: " + string(output))
 		return nil, err
@@ -428,13 +441,13 @@ func (c *Container) createUser() (pswd *string, err error) {
 func (c *Container) removeUser() (err error) {
 	username := c.Username()
 	c.logger().Info("removing user " + username)
-	output, err := exec.Command("/usr/sbin/userdel", "-f", username).Output()
+	output, err := tracing.Output(c.context(), exec.Command("/usr/sbin/userdel", "-f", username))
 	if err != nil {
 		c.logger().Error("unable to delete user " + username + ": " + string(output))
 	}
 	homeDir := filepath.Join(directory, username)
 	c.logger().Info("removing mount: " + homeDir)
-	output, err2 := exec.Command("umount", "-l", filepath.Join(homeDir, "data")).Output()
+	output, err2 := tracing.Output(c.context(), exec.Command("umount", "-l", filepath.Join(homeDir, "data")))
 	if err2 != nil {
 		c.logger().Error("unable to unmount " + username + ": " + string(output))
 		err = err2
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"encoding/json"
+	"github.com/docker/docker/client"
+	"supervisor/machine/container/listener"
+)
+
+/*
+*
+docker label carrying the watchers and readiness the container was created with, so a
+container loaded back from its docker name after a supervisor restart keeps them.
+watchers added later through the watch command aren't part of it, they only last as
+long as the supervisor process
+*/
+const watchLabel = "serverbench.watch"
+
+type watchSpec struct {
+	Watchers  []listener.Watcher  `json:"watchers,omitempty"`
+	Readiness *listener.Readiness `json:"readiness,omitempty"`
+}
+
+func (s watchSpec) empty() bool {
+	return len(s.Watchers) <= 0 && s.Readiness == nil
+}
+
+/*
+*
+what the container watches for as hosted, template watchers go first so container
+specific ones can override them by id
+*/
+func (c *Container) watchSpec() watchSpec {
+	return watchSpec{
+		Watchers:  append(append([]listener.Watcher{}, c.Template.Watchers...), c.Watchers...),
+		Readiness: c.Template.Readiness,
+	}
+}
+
+func (c *Container) watchLabels() (labels map[string]string, err error) {
+	spec := c.watchSpec()
+	if spec.empty() {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{watchLabel: string(encoded)}, nil
+}
+
+/*
+*
+the spec stored on the docker container, empty when there is no container (yet) or
+it was created without one
+*/
+func (c *Container) storedWatchSpec(cli *client.Client) (spec watchSpec) {
+	inspect, err := cli.ContainerInspect(c.context(), c.Username())
+	if err != nil || inspect.Config == nil {
+		return spec
+	}
+	encoded, ok := inspect.Config.Labels[watchLabel]
+	if !ok {
+		return spec
+	}
+	err = json.Unmarshal([]byte(encoded), &spec)
+	if err != nil {
+		c.logger().Warn("ignoring unreadable watch label: ", err)
+		return watchSpec{}
+	}
+	return spec
+}
@@ -1,14 +1,22 @@
 package ip
 
 import (
+	"context"
 	"errors"
 	"net"
 	"os/exec"
 	"strconv"
+	"supervisor/machine/tracing"
 )
 
 type FirewallPolicy string
 
+var (
+	SshPortErr       = errors.New("can't modify ssh port")
+	InvalidSourceErr = errors.New("invalid source ip")
+	UnknownPolicyErr = errors.New("unknown firewall policy")
+)
+
 const (
 	Blacklist FirewallPolicy = "blacklist"
 	Whitelist                = "whitelist"
@@ -21,13 +29,13 @@ type Port struct {
 	Ip       Ip             `json:"ip"`
 }
 
-func (p *Port) CreateRules(chainName string) (err error) {
+func (p *Port) CreateRules(ctx context.Context, chainName string) (err error) {
 	if p.Port == 22 {
-		return errors.New("can't modify ssh port")
+		return SshPortErr
 	}
 	usedIpParsed := net.ParseIP(p.Ip.Ip)
 	if usedIpParsed == nil {
-		return errors.New("invalid source ip")
+		return InvalidSourceErr
 	}
 	var utility string
 	if usedIpParsed.To4() != nil {
@@ -49,15 +57,17 @@ func (p *Port) CreateRules(chainName string) (err error) {
 		actionInList = drop
 		actionOutsideList = accept
 	} else {
-		err = errors.New("unknown firewall policy")
+		err = UnknownPolicyErr
 		return err
 	}
-	for _, rule := range p.Rules {
+	for i := range p.Rules {
+		// rules cache their resolved ips, so they must not be copied
+		rule := &p.Rules[i]
 		resolvedIps, err := rule.GetIps()
 		if err == nil {
 			for _, ip := range resolvedIps {
 				for _, proto := range protos {
-					err = exec.Command(utility, "-A", chainName, "-p", p.Ip.Adapter, "-s", ip.String(), "-d", p.Ip.Ip, "-p", proto, "--dport", strconv.Itoa(p.Port), "-j", actionInList).Run()
+					err = tracing.Run(ctx, exec.Command(utility, "-A", chainName, "-p", p.Ip.Adapter, "-s", ip.String(), "-d", p.Ip.Ip, "-p", proto, "--dport", strconv.Itoa(p.Port), "-j", actionInList))
 					if err != nil {
 						return err
 					}
@@ -66,7 +76,7 @@ func (p *Port) CreateRules(chainName string) (err error) {
 		}
 	}
 	for _, proto := range protos {
-		err = exec.Command(utility, "-A", chainName, "-p", p.Ip.Adapter, "-d", p.Ip.Ip, "-p", proto, "--dport", strconv.Itoa(p.Port), "-j", actionOutsideList).Run()
+		err = tracing.Run(ctx, exec.Command(utility, "-A", chainName, "-p", p.Ip.Adapter, "-d", p.Ip.Ip, "-p", proto, "--dport", strconv.Itoa(p.Port), "-j", actionOutsideList))
 		if err != nil {
 			return err
 		}
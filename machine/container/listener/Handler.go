@@ -5,8 +5,12 @@ import (
 	"errors"
 	"github.com/docker/docker/client"
 	log "github.com/sirupsen/logrus"
+	"strings"
 	"supervisor/machine/container/listener/event"
 	"supervisor/machine/container/listener/stream"
+	"supervisor/machine/metrics"
+	"sync"
+	"time"
 )
 
 type Handler struct {
@@ -14,6 +18,10 @@ type Handler struct {
 	Logs     []Subscriber
 	Progress []Subscriber
 	Load     []Subscriber
+	Alerts   []Subscriber
+	// guards the subscriber lists and the progress cache, subscriptions run outside the
+	// container queue while events are forwarded
+	subscriberMutex sync.Mutex
 	// global
 	Client *client.Client
 	// id
@@ -21,30 +29,49 @@ type Handler struct {
 	ContainerName string
 	// local event stream
 	internalEvents *chan event.Entry
-	eventPool      *chan event.Entry
+	eventPool      *event.Queue
 	// logs
 	LogStream *stream.Stream
 	// load
 	LoadStream *stream.Stream
 	// activity map
 	ProgressCache map[string]event.ProgressUpdate
+	activities    map[string]context.CancelFunc
+	activityMutex sync.Mutex
+	// log watchers
+	Watchers     []*Watcher
+	watchMutex   sync.Mutex
+	logRemainder string
+	// log based readiness
+	Readiness      *Readiness
+	readiness      event.ReadinessState
+	readinessTimer *time.Timer
+	readinessMutex sync.Mutex
 }
 
 var (
-	MissingStatusErr = errors.New("in order to listen for log/load events, you must also attach to status events")
+	MissingStatusErr     = errors.New("in order to listen for log/load/alert events, you must also attach to status events")
+	AlreadyForwardingErr = errors.New("already forwarding events")
+	MissingEventPoolErr  = errors.New("missing event pool")
+	NotForwardingErr     = errors.New("missing out channel")
+	UnknownEventErr      = errors.New("unknown action")
+	MissingActivityErr   = errors.New("activity not found")
 )
 
+// partial log lines longer than this are evaluated as a whole line
+const maxLogLine = 8192
+
 /*
 *
 create streams and forward events
 */
-func (h *Handler) Forward(Out *chan event.Entry) (err error) {
+func (h *Handler) Forward(Out *event.Queue) (err error) {
 	if h.internalEvents != nil {
-		err = errors.New("already forwarding events")
+		err = AlreadyForwardingErr
 		return err
 	}
 	if Out == nil {
-		err = errors.New("missing event pool")
+		err = MissingEventPoolErr
 		return err
 	}
 	h.eventPool = Out
@@ -68,6 +95,17 @@ func (h *Handler) Forward(Out *chan event.Entry) (err error) {
 	h.LoadStream = &loadStream
 	go func() {
 		for entry := range *h.internalEvents {
+			if entry.Type == event.Log {
+				h.watch(entry.Content)
+				if !h.hasSubscribers(event.Log) {
+					// the log stream runs internally for watchers and readiness, nobody to forward to
+					continue
+				}
+			}
+			if entry.Type == event.Load && !h.hasSubscribers(event.Load) {
+				// streamed for the metrics only
+				continue
+			}
 			err = h.HandleEvent(entry.Type, entry.Content, false)
 		}
 	}()
@@ -75,24 +113,89 @@ func (h *Handler) Forward(Out *chan event.Entry) (err error) {
 }
 
 func (h *Handler) logger() (entry *log.Entry) {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
 	return log.WithFields(log.Fields{
-		"handler":  h.ContainerId,
-		"logs":     len(h.Logs),
-		"progress": len(h.Progress),
-		"status":   len(h.Status),
-		"load":     len(h.Load),
+		"container": h.ContainerId,
+		"logs":      len(h.Logs),
+		"progress":  len(h.Progress),
+		"status":    len(h.Status),
+		"load":      len(h.Load),
+		"alerts":    len(h.Alerts),
 	})
 }
 
+/*
+*
+the subscriber list of an event type, nil for unknown types. callers hold subscriberMutex
+*/
+func (h *Handler) subscriberList(action event.Type) *[]Subscriber {
+	switch action {
+	case event.Log:
+		return &h.Logs
+	case event.Status:
+		return &h.Status
+	case event.Progress:
+		return &h.Progress
+	case event.Load:
+		return &h.Load
+	case event.Alert:
+		return &h.Alerts
+	}
+	return nil
+}
+
+func (h *Handler) hasSubscribers(action event.Type) bool {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
+	list := h.subscriberList(action)
+	return list != nil && len(*list) > 0
+}
+
+func (h *Handler) addSubscriber(action event.Type, subscriber Subscriber) {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
+	list := h.subscriberList(action)
+	*list = append(*list, subscriber)
+}
+
+/*
+*
+keeps ongoing activities so they can be sent to new progress subscribers, finished
+ones are dropped
+*/
+func (h *Handler) TrackProgress(progress event.ProgressUpdate) {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
+	if progress.Finished {
+		delete(h.ProgressCache, progress.Id)
+		return
+	}
+	if h.ProgressCache == nil {
+		h.ProgressCache = make(map[string]event.ProgressUpdate)
+	}
+	h.ProgressCache[progress.Id] = progress
+}
+
+func (h *Handler) OngoingProgress() (ongoing []event.ProgressUpdate) {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
+	ongoing = make([]event.ProgressUpdate, 0, len(h.ProgressCache))
+	for _, progress := range h.ProgressCache {
+		ongoing = append(ongoing, progress)
+	}
+	return ongoing
+}
+
 func (h *Handler) Subscribe(listener Subscriber) (err error) {
 	h.logger().Info("subscribing ", listener)
 	if h.internalEvents == nil {
-		err = errors.New("missing out channel")
+		err = NotForwardingErr
 		return err
 	}
 	var status string
 	if listener.Level.Status {
-		h.Status = append(h.Status, listener)
+		h.addSubscriber(event.Status, listener)
 		inspect, err := h.Client.ContainerInspect(context.Background(), h.ContainerName)
 		if err != nil {
 			return err
@@ -104,8 +207,8 @@ func (h *Handler) Subscribe(listener Subscriber) (err error) {
 		}
 	}
 	if listener.Level.Progress {
-		h.Progress = append(h.Progress, listener)
-		for _, ongoingProgress := range h.ProgressCache {
+		h.addSubscriber(event.Progress, listener)
+		for _, ongoingProgress := range h.OngoingProgress() {
 			encodedProgressUpdate, err := ongoingProgress.Encode()
 			if err != nil {
 				return err
@@ -122,10 +225,17 @@ func (h *Handler) Subscribe(listener Subscriber) (err error) {
 			// to re-attach to the container logs
 			err = MissingStatusErr
 		} else {
-			h.Logs = append(h.Logs, listener)
-			go func() {
-				_ = h.LogStream.StreamLogs()
-			}()
+			h.addSubscriber(event.Log, listener)
+			h.LogStream.Follow()
+		}
+	}
+	if listener.Level.Alerts {
+		if !listener.Level.Status {
+			// alerts are evaluated over the log stream, which has the same restart constraints
+			err = MissingStatusErr
+		} else {
+			h.addSubscriber(event.Alert, listener)
+			h.LogStream.Follow()
 		}
 	}
 	if listener.Level.Load {
@@ -134,10 +244,8 @@ func (h *Handler) Subscribe(listener Subscriber) (err error) {
 			// to re-attach to the container load
 			err = MissingStatusErr
 		} else {
-			h.Load = append(h.Load, listener)
-			go func() {
-				_ = h.LoadStream.StreamLoad()
-			}()
+			h.addSubscriber(event.Load, listener)
+			h.LoadStream.Follow()
 		}
 	}
 	h.logger().Info("subscribed ", listener)
@@ -145,22 +253,29 @@ func (h *Handler) Subscribe(listener Subscriber) (err error) {
 }
 
 func (h *Handler) Unsubscribe(subscriber Subscriber) (err error) {
+	h.subscriberMutex.Lock()
+	defer h.subscriberMutex.Unlock()
 	_, err = h.cleanSubscriberList(subscriber, &h.Status)
 	if err != nil {
 		return err
 	}
-	empty, err := h.cleanSubscriberList(subscriber, &h.Logs)
+	_, err = h.cleanSubscriberList(subscriber, &h.Logs)
 	if err != nil {
 		return err
 	}
-	if empty {
-		h.LogStream.Close()
+	_, err = h.cleanSubscriberList(subscriber, &h.Alerts)
+	if err != nil {
+		return err
 	}
 
 	_, err = h.cleanSubscriberList(subscriber, &h.Progress)
 	if err != nil {
 		return err
 	}
+	_, err = h.cleanSubscriberList(subscriber, &h.Load)
+	if err != nil {
+		return err
+	}
 	return err
 }
 
@@ -183,36 +298,30 @@ func (h *Handler) cleanSubscriberList(subscriber Subscriber, subscriberList *[]S
 }
 
 func (h *Handler) HandleEvent(action event.Type, content string, broadcast bool) (err error) {
-	var targetListeners *[]Subscriber = nil
+	targetIds := make([]string, 0)
 	if broadcast {
-		broadcastIds := []Subscriber{{
-			Id: "*",
-		}}
-		targetListeners = &broadcastIds
+		targetIds = append(targetIds, "*")
 	} else {
-		if action == event.Log {
-			targetListeners = &h.Logs
-		} else if action == event.Status {
-			targetListeners = &h.Status
-		} else if action == event.Progress {
-			targetListeners = &h.Progress
-		} else if action == event.Load {
-			targetListeners = &h.Load
-		} else {
-			err = errors.New("unknown action")
+		h.subscriberMutex.Lock()
+		targetListeners := h.subscriberList(action)
+		if targetListeners != nil {
+			for _, listener := range *targetListeners {
+				targetIds = append(targetIds, listener.Id)
+			}
+		}
+		h.subscriberMutex.Unlock()
+		if targetListeners == nil {
+			err = UnknownEventErr
 			h.logger().Errorf("unknown event %s, %s", action, content)
 			return err
 		}
 	}
-	targetIds := make([]string, 0)
-	for _, listener := range *targetListeners {
-		targetIds = append(targetIds, listener.Id)
-	}
 	entry := event.Entry{
 		Listeners: targetIds,
 		Type:      action,
 		Container: h.ContainerId,
 		Content:   content,
+		Timestamp: time.Now(),
 	}
 	if entry.Type == event.Status {
 		inspect, err := h.Client.ContainerInspect(context.Background(), h.ContainerName)
@@ -220,25 +329,145 @@ func (h *Handler) HandleEvent(action event.Type, content string, broadcast bool)
 			return err
 		}
 		status := event.StatusUpdate{}
-		encodedStatus, err := status.FromContainerState(inspect.State).Encode()
+		status.FromContainerState(inspect.State).Readiness = h.updateReadiness(status.Running)
+		encodedStatus, err := status.Encode()
 		if err != nil {
 			return err
 		}
 		entry.Content = encodedStatus
 		if status.Running {
-			if len(h.Logs) > 0 {
-				go func() {
-					_ = h.LogStream.StreamLogs()
-				}()
-			}
-			if len(h.Load) > 0 {
-				go func() {
-					_ = h.LoadStream.StreamLoad()
-				}()
+			// logs are always streamed, watchers and readiness depend on them
+			h.LogStream.Follow()
+			// load feeds the metrics even while nobody watches it
+			if h.hasSubscribers(event.Load) || metrics.Enabled() {
+				h.LoadStream.Follow()
 			}
 		}
 	}
-	*h.eventPool <- entry
+	h.eventPool.Push(entry)
 	h.logger().Infof("forwarded entry %s, %s", action, content)
 	return err
 }
+
+func (h *Handler) AddWatcher(watcher Watcher) (err error) {
+	err = watcher.Compile()
+	if err != nil {
+		return err
+	}
+	h.watchMutex.Lock()
+	defer h.watchMutex.Unlock()
+	for i, existing := range h.Watchers {
+		if existing.Id == watcher.Id {
+			h.Watchers[i] = &watcher
+			h.logger().Info("replaced watcher ", watcher.Id)
+			return nil
+		}
+	}
+	h.Watchers = append(h.Watchers, &watcher)
+	h.logger().Info("added watcher ", watcher.Id)
+	return nil
+}
+
+func (h *Handler) RemoveWatcher(id string) (err error) {
+	h.watchMutex.Lock()
+	defer h.watchMutex.Unlock()
+	for i, existing := range h.Watchers {
+		if existing.Id == id {
+			h.Watchers = append(h.Watchers[:i], h.Watchers[i+1:]...)
+			h.logger().Info("removed watcher ", id)
+			return nil
+		}
+	}
+	return MissingWatcherErr
+}
+
+func (h *Handler) ListWatchers() (watchers []Watcher) {
+	h.watchMutex.Lock()
+	defer h.watchMutex.Unlock()
+	watchers = make([]Watcher, 0, len(h.Watchers))
+	for _, watcher := range h.Watchers {
+		watchers = append(watchers, Watcher{
+			Id:       watcher.Id,
+			Pattern:  watcher.Pattern,
+			Cooldown: watcher.Cooldown,
+		})
+	}
+	return watchers
+}
+
+/*
+*
+splits raw log output into lines and runs readiness and every watcher over each complete line.
+incomplete trailing lines are kept until the next chunk arrives
+*/
+func (h *Handler) watch(content string) {
+	h.watchMutex.Lock()
+	if len(h.Watchers) <= 0 && !h.awaitsReadiness() {
+		h.logRemainder = ""
+		h.watchMutex.Unlock()
+		return
+	}
+	lines := strings.Split(h.logRemainder+content, "\n")
+	h.logRemainder = lines[len(lines)-1]
+	lines = lines[:len(lines)-1]
+	if len(h.logRemainder) > maxLogLine {
+		lines = append(lines, h.logRemainder)
+		h.logRemainder = ""
+	}
+	// alerts and readiness go back through HandleEvent, which must not run under the lock.
+	// evaluation only happens on the forwarding goroutine, so the watchers can be used unlocked
+	watchers := make([]*Watcher, len(h.Watchers))
+	copy(watchers, h.Watchers)
+	h.watchMutex.Unlock()
+	now := time.Now()
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		h.checkReadiness(line)
+		for _, watcher := range watchers {
+			alert := watcher.Evaluate(line, now)
+			if alert == nil {
+				continue
+			}
+			encodedAlert, err := alert.Encode()
+			if err != nil {
+				h.logger().Warn("unable to encode alert: ", err)
+				continue
+			}
+			err = h.HandleEvent(event.Alert, encodedAlert, false)
+			if err != nil {
+				h.logger().Warn("unable to forward alert: ", err)
+			}
+		}
+	}
+}
+
+/*
+*
+makes a running activity cancellable through CancelActivity until it is released
+*/
+func (h *Handler) RegisterActivity(id string, cancel context.CancelFunc) {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+	if h.activities == nil {
+		h.activities = make(map[string]context.CancelFunc)
+	}
+	h.activities[id] = cancel
+}
+
+func (h *Handler) ReleaseActivity(id string) {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+	delete(h.activities, id)
+}
+
+func (h *Handler) CancelActivity(id string) (err error) {
+	h.activityMutex.Lock()
+	cancel, ok := h.activities[id]
+	h.activityMutex.Unlock()
+	if !ok {
+		return MissingActivityErr
+	}
+	h.logger().Info("cancelling activity ", id)
+	cancel()
+	return nil
+}
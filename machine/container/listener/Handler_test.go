@@ -0,0 +1,174 @@
+package listener
+
+import (
+	"github.com/docker/docker/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"supervisor/machine/container/listener/event"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+*
+a docker api that reports the container as running and keeps every followed log request
+open until it is released, other log requests get the logged output
+*/
+type fakeDocker struct {
+	mutex     sync.Mutex
+	logs      int
+	release   chan struct{}
+	startedAt time.Time
+	logged    string
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	startedAt, logged := d.startedAt, d.logged
+	d.mutex.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/json"):
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"Id":"c1","State":{"Status":"running","Running":true,"StartedAt":"` + startedAt.Format(time.RFC3339Nano) + `"}}`))
+	case strings.HasSuffix(r.URL.Path, "/logs") && r.URL.Query().Get("follow") != "1":
+		_, _ = w.Write([]byte(logged))
+	case strings.HasSuffix(r.URL.Path, "/logs"):
+		d.mutex.Lock()
+		d.logs++
+		release := d.release
+		d.mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *fakeDocker) logRequests() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.logs
+}
+
+func (d *fakeDocker) endLogs() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	close(d.release)
+	d.release = make(chan struct{})
+}
+
+func newHandler(t *testing.T) (h *Handler, docker *fakeDocker) {
+	docker = &fakeDocker{release: make(chan struct{})}
+	server := httptest.NewServer(docker)
+	t.Cleanup(func() {
+		docker.endLogs()
+		server.Close()
+	})
+	cli, err := client.NewClientWithOpts(client.WithHost(server.URL), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = &Handler{Client: cli, ContainerId: "c1", ContainerName: "c1"}
+	err = h.Forward(event.NewQueue(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, docker
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/*
+*
+the handler state must stay readable, a stuck stream lock hangs it
+*/
+func stateWithin(t *testing.T, h *Handler) State {
+	read := make(chan State, 1)
+	go func() {
+		read <- h.State()
+	}()
+	select {
+	case state := <-read:
+		return state
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler state blocked, the stream lock was not released")
+	}
+	return State{}
+}
+
+func TestRepeatedRunningStatusKeepsOneLogStream(t *testing.T) {
+	h, docker := newHandler(t)
+	for i := 0; i < 2; i++ {
+		err := h.HandleEvent(event.Status, "running", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the log stream to open", func() bool {
+		return stateWithin(t, h).Streams["logs"].Open
+	})
+	err := h.LogStream.StreamLogs()
+	if err == nil {
+		t.Fatal("expected a second stream on the same container to be refused")
+	}
+	stateWithin(t, h)
+	if requests := docker.logRequests(); requests != 1 {
+		t.Fatalf("expected a single log request, got %d", requests)
+	}
+
+	// the container restarts, the next running status must reopen the stream
+	docker.endLogs()
+	waitFor(t, "the log stream to close", func() bool {
+		return !stateWithin(t, h).Streams["logs"].Open
+	})
+	err = h.HandleEvent(event.Status, "running", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the log stream to reopen", func() bool {
+		return docker.logRequests() == 2 && stateWithin(t, h).Streams["logs"].Open
+	})
+}
+
+func TestResumeRecomputesReadiness(t *testing.T) {
+	cases := []struct {
+		name     string
+		started  time.Duration
+		logged   string
+		expected event.ReadinessState
+	}{
+		{"logged before the restart", time.Minute, "Loading\r\nDone (2.345s)!\r\n", event.Ready},
+		{"still within the timeout", time.Second, "Loading\r\n", event.Starting},
+		{"past the timeout", time.Minute, "Loading\r\n", event.StartupTimeout},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, docker := newHandler(t)
+			docker.mutex.Lock()
+			docker.startedAt, docker.logged = time.Now().Add(-c.started), c.logged
+			docker.mutex.Unlock()
+			err := h.SetReadiness(&Readiness{Pattern: `Done \(.*\)!`, Timeout: 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.Resume()
+			if state := stateWithin(t, h); state.Readiness != c.expected {
+				t.Fatalf("expected %s, got %s", c.expected, state.Readiness)
+			}
+		})
+	}
+}
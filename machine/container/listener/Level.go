@@ -5,4 +5,5 @@ type Level struct {
 	Logs     bool `json:"logs"`
 	Progress bool `json:"progress"`
 	Load     bool `json:"load"`
+	Alerts   bool `json:"alerts"`
 }
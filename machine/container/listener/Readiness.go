@@ -0,0 +1,32 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	InvalidReadinessErr = errors.New("invalid readiness")
+)
+
+type Readiness struct {
+	Pattern string `json:"pattern"`
+	Timeout int    `json:"timeout"` // seconds the container has to match the pattern after starting
+	regex   *regexp.Regexp
+}
+
+func (r *Readiness) Compile() (err error) {
+	if r.Timeout <= 0 {
+		return fmt.Errorf("%w: timeout must be positive", InvalidReadinessErr)
+	}
+	r.regex, err = regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %w", InvalidReadinessErr, err)
+	}
+	return nil
+}
+
+func (r *Readiness) Matches(line string) bool {
+	return r.regex != nil && r.regex.MatchString(line)
+}
@@ -0,0 +1,177 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"github.com/docker/docker/api/types/container"
+	"io"
+	"strconv"
+	"strings"
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/metrics"
+	"time"
+)
+
+// bytes of logs read when looking for a readiness line that was logged before a restart
+const readinessScanLimit = 16 << 20
+
+func (h *Handler) SetReadiness(readiness *Readiness) (err error) {
+	if readiness != nil {
+		err = readiness.Compile()
+		if err != nil {
+			return err
+		}
+	}
+	h.readinessMutex.Lock()
+	h.Readiness = readiness
+	h.readinessMutex.Unlock()
+	return nil
+}
+
+/*
+*
+picks up a container that was already running before the handler existed (ie. a
+supervisor restart)
+*/
+func (h *Handler) Resume() {
+	inspect, err := h.Client.ContainerInspect(context.Background(), h.ContainerName)
+	if err != nil || inspect.State == nil || !inspect.State.Running {
+		return
+	}
+	h.resumeReadiness(inspect.State.StartedAt)
+	h.LogStream.Follow()
+	if metrics.Enabled() {
+		h.LoadStream.Follow()
+	}
+}
+
+/*
+*
+recomputes the readiness of a container that was already running: ready once its
+readiness line shows up in the logs since it started, otherwise starting or timed out
+depending on how long ago it started
+*/
+func (h *Handler) resumeReadiness(startedAt string) {
+	h.readinessMutex.Lock()
+	readiness := h.Readiness
+	h.readinessMutex.Unlock()
+	started, err := time.Parse(time.RFC3339Nano, startedAt)
+	ready := readiness == nil || err != nil || h.loggedReadiness(readiness, started)
+	h.readinessMutex.Lock()
+	defer h.readinessMutex.Unlock()
+	h.stopReadinessTimer()
+	if ready {
+		h.readiness = event.Ready
+		return
+	}
+	remaining := time.Until(started.Add(time.Duration(readiness.Timeout) * time.Second))
+	if remaining <= 0 {
+		h.readiness = event.StartupTimeout
+		return
+	}
+	h.readiness = event.Starting
+	h.readinessTimer = time.AfterFunc(remaining, h.readinessTimedOut)
+	h.logger().Info("waiting for readiness")
+}
+
+/*
+*
+scans the logs written since the container started for the readiness line, up to a
+limit so a long running container without a match doesn't get read in full
+*/
+func (h *Handler) loggedReadiness(readiness *Readiness, started time.Time) bool {
+	logs, err := h.Client.ContainerLogs(context.Background(), h.ContainerName, container.LogsOptions{
+		Since:      strconv.FormatInt(started.Unix(), 10),
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		h.logger().Warn("unable to read the logs for readiness: ", err)
+		return false
+	}
+	defer logs.Close()
+	scanner := bufio.NewScanner(io.LimitReader(logs, readinessScanLimit))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if readiness.Matches(strings.TrimRight(scanner.Text(), "\r")) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+moves the readiness state according to the docker state, a container that starts
+running will be starting until its readiness pattern shows up in the logs or the
+timeout expires
+*/
+func (h *Handler) updateReadiness(running bool) (state event.ReadinessState) {
+	h.readinessMutex.Lock()
+	defer h.readinessMutex.Unlock()
+	if !running {
+		h.stopReadinessTimer()
+		h.readiness = ""
+		return h.readiness
+	}
+	if h.readiness != "" {
+		return h.readiness
+	}
+	if h.Readiness == nil {
+		h.readiness = event.Ready
+		return h.readiness
+	}
+	h.readiness = event.Starting
+	h.readinessTimer = time.AfterFunc(time.Duration(h.Readiness.Timeout)*time.Second, h.readinessTimedOut)
+	h.logger().Info("waiting for readiness")
+	return h.readiness
+}
+
+func (h *Handler) readinessTimedOut() {
+	h.readinessMutex.Lock()
+	timedOut := h.readiness == event.Starting
+	if timedOut {
+		h.readiness = event.StartupTimeout
+	}
+	h.readinessMutex.Unlock()
+	if timedOut {
+		h.logger().Warn("container did not become ready in time")
+		_ = h.HandleEvent(event.Status, "", false)
+	}
+}
+
+func (h *Handler) stopReadinessTimer() {
+	if h.readinessTimer != nil {
+		h.readinessTimer.Stop()
+		h.readinessTimer = nil
+	}
+}
+
+func (h *Handler) awaitsReadiness() bool {
+	h.readinessMutex.Lock()
+	defer h.readinessMutex.Unlock()
+	return h.Readiness != nil && (h.readiness == event.Starting || h.readiness == event.StartupTimeout)
+}
+
+/*
+*
+a late readiness match still flips a timed out container to ready
+*/
+func (h *Handler) checkReadiness(line string) {
+	h.readinessMutex.Lock()
+	matched := h.Readiness != nil &&
+		(h.readiness == event.Starting || h.readiness == event.StartupTimeout) &&
+		h.Readiness.Matches(line)
+	if matched {
+		h.stopReadinessTimer()
+		h.readiness = event.Ready
+	}
+	h.readinessMutex.Unlock()
+	if matched {
+		h.logger().Info("container is ready")
+		err := h.HandleEvent(event.Status, "", false)
+		if err != nil {
+			h.logger().Warn("unable to forward readiness: ", err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package listener
+
+import (
+	"supervisor/machine/container/listener/event"
+	"supervisor/machine/container/listener/stream"
+)
+
+/*
+*
+point in time view of a handler, for local inspection
+*/
+type State struct {
+	Subscribers map[string][]string     `json:"subscribers"`
+	Streams     map[string]stream.State `json:"streams"`
+	Watchers    []Watcher               `json:"watchers"`
+	Readiness   event.ReadinessState    `json:"readiness"`
+	Activities  []event.ProgressUpdate  `json:"activities"`
+}
+
+func (h *Handler) State() (state State) {
+	ids := func(subscribers []Subscriber) []string {
+		list := make([]string, 0, len(subscribers))
+		for _, subscriber := range subscribers {
+			list = append(list, subscriber.Id)
+		}
+		return list
+	}
+	h.subscriberMutex.Lock()
+	state.Subscribers = map[string][]string{
+		"status":   ids(h.Status),
+		"logs":     ids(h.Logs),
+		"progress": ids(h.Progress),
+		"load":     ids(h.Load),
+		"alerts":   ids(h.Alerts),
+	}
+	h.subscriberMutex.Unlock()
+	state.Streams = make(map[string]stream.State)
+	if h.LogStream != nil {
+		state.Streams["logs"] = h.LogStream.State()
+	}
+	if h.LoadStream != nil {
+		state.Streams["load"] = h.LoadStream.State()
+	}
+	state.Watchers = h.ListWatchers()
+	h.readinessMutex.Lock()
+	state.Readiness = h.readiness
+	h.readinessMutex.Unlock()
+	state.Activities = h.OngoingProgress()
+	return state
+}
@@ -0,0 +1,65 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"supervisor/machine/container/listener/event"
+	"time"
+)
+
+type Watcher struct {
+	Id       string `json:"id"`
+	Pattern  string `json:"pattern"`
+	Cooldown int    `json:"cooldown"` // seconds between two alerts of the same watcher
+	regex    *regexp.Regexp
+	lastFire time.Time
+	skipped  int
+}
+
+var (
+	MissingWatcherErr = errors.New("watcher not found")
+	InvalidWatcherErr = errors.New("invalid watcher")
+)
+
+func (w *Watcher) Compile() (err error) {
+	if len(w.Id) <= 0 {
+		return fmt.Errorf("%w: missing id", InvalidWatcherErr)
+	}
+	if w.Cooldown < 0 {
+		return fmt.Errorf("%w: negative cooldown", InvalidWatcherErr)
+	}
+	w.regex, err = regexp.Compile(w.Pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %w", InvalidWatcherErr, err)
+	}
+	return nil
+}
+
+/*
+*
+evaluates a single log line, returning the alert to be emitted (if any). matches
+within the cooldown window are counted and reported on the next emitted alert
+*/
+func (w *Watcher) Evaluate(line string, now time.Time) (alert *event.AlertUpdate) {
+	if w.regex == nil {
+		return nil
+	}
+	match := w.regex.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	if !w.lastFire.IsZero() && now.Sub(w.lastFire) < time.Duration(w.Cooldown)*time.Second {
+		w.skipped++
+		return nil
+	}
+	alert = &event.AlertUpdate{
+		Watcher:    w.Id,
+		Line:       line,
+		Groups:     match[1:],
+		Suppressed: w.skipped,
+	}
+	w.lastFire = now
+	w.skipped = 0
+	return alert
+}
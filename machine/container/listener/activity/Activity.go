@@ -1,12 +1,24 @@
 package activity
 
 import (
+	"context"
+	"errors"
 	"github.com/thanhpk/randstr"
+	"go.opentelemetry.io/otel/attribute"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"supervisor/machine/container/listener"
 	"supervisor/machine/container/listener/event"
+	"supervisor/machine/metrics"
+	"supervisor/machine/redact"
+	"supervisor/machine/tracing"
+	"syscall"
+	"time"
+)
+
+var (
+	CancelledErr = errors.New("activity cancelled")
 )
 
 type Activity struct {
@@ -18,28 +30,58 @@ type Activity struct {
 	ProgressIndex       int
 	HeadSha             *string
 	Type                string
+	Context             context.Context // optional parent, cancelling it cancels the activity
 	progress            int
 	originalDescription string
 	id                  string
+	cancelled           bool
 }
 
 func (a *Activity) Exec(handler *listener.Handler) (err error) {
 	a.id = randstr.Hex(8)
 	a.progress = 0
 	a.originalDescription = a.Description
+	parent := a.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := tracing.Start(parent, "activity "+a.Type,
+		attribute.String("activity.id", a.id),
+		attribute.String("activity.description", redact.String(a.Description)),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	handler.RegisterActivity(a.id, cancel)
+	defer handler.ReleaseActivity(a.id)
 	a.Forward(handler, false, false, true)
+	started := time.Now()
 
 	stdout, err := a.Command.StdoutPipe()
 	if err != nil {
 		return err
 	}
 	a.Command.Stderr = a.Command.Stdout
+	// own process group, so cancelling also takes down whatever the command spawned
+	a.Command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	// Start the command
 	if err := a.Command.Start(); err != nil {
 		return err
 	}
 
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = syscall.Kill(-a.Command.Process.Pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+
 	go func() {
 		for {
 			buffer := make([]byte, 1024)
@@ -68,11 +110,19 @@ func (a *Activity) Exec(handler *listener.Handler) (err error) {
 	}()
 
 	if err := a.Command.Wait(); err != nil {
+		if ctx.Err() != nil {
+			a.cancelled = true
+			a.Forward(handler, true, false, false)
+			metrics.ObserveActivity(a.Type, "cancelled", time.Since(started))
+			return CancelledErr
+		}
 		a.Forward(handler, true, true, false)
+		metrics.ObserveActivity(a.Type, "failed", time.Since(started))
 		return err
 	} else {
 		a.progress = 100
 		a.Forward(handler, true, false, false)
+		metrics.ObserveActivity(a.Type, "succeeded", time.Since(started))
 	}
 
 	return nil
@@ -86,11 +136,13 @@ func (a *Activity) Forward(handler *listener.Handler, finished bool, errored boo
 		Started:     started,
 		Finished:    finished,
 		Errored:     errored,
+		Cancelled:   a.cancelled,
+		Cancellable: !finished,
 		Progress:    a.progress,
 		HeadSha:     a.HeadSha,
 		Type:        a.Type,
 	}
-	handler.ProgressCache[a.id] = progress
+	handler.TrackProgress(progress)
 	enc, err := progress.Encode()
 	if err != nil {
 		return
@@ -99,7 +151,4 @@ func (a *Activity) Forward(handler *listener.Handler, finished bool, errored boo
 	if started || finished {
 		_ = handler.HandleEvent(event.Progress, enc, true)
 	}
-	if finished {
-		delete(handler.ProgressCache, a.id)
-	}
 }
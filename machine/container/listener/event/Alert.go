@@ -0,0 +1,21 @@
+package event
+
+import (
+	"encoding/json"
+)
+
+type AlertUpdate struct {
+	Watcher    string   `json:"watcher"`
+	Line       string   `json:"line"`
+	Groups     []string `json:"groups"`
+	Suppressed int      `json:"suppressed"`
+}
+
+func (a *AlertUpdate) Encode() (content string, err error) {
+	contentBytes, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	content = string(contentBytes)
+	return content, err
+}
@@ -1,8 +1,16 @@
 package event
 
+import "time"
+
 type Entry struct {
 	Listeners []string `json:"listeners"`
 	Type      Type     `json:"type"`
 	Container string   `json:"container"`
 	Content   string   `json:"content"`
+	// dropped right before this one for the same type and container, in lines for logs
+	// and in entries for every other type
+	Dropped int `json:"dropped,omitempty"`
+	// assigned by the journal when the entry leaves the supervisor
+	Sequence  uint64    `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
 }
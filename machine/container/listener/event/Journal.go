@@ -0,0 +1,145 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/thanhpk/randstr"
+	"os"
+	"sync"
+)
+
+/*
+*
+keeps the most recent outgoing entries so they can be replayed after a
+reconnect. entries beyond the in-memory capacity are spilled to disk (when a
+spill path is set) up to a byte limit, after which the spill file starts over.
+the spill file only extends the capacity, it is truncated on first use and the
+journal never outlives the process, which the epoch identifies
+*/
+type Journal struct {
+	Epoch      string
+	Capacity   int
+	SpillPath  string
+	SpillLimit int64
+	mutex      sync.Mutex
+	sequence   uint64
+	memory     []Entry
+	spill      *os.File
+	spilled    int64
+}
+
+func NewJournal(capacity int, spillPath string, spillLimit int64) *Journal {
+	return &Journal{
+		Epoch:      randstr.Hex(8),
+		Capacity:   capacity,
+		SpillPath:  spillPath,
+		SpillLimit: spillLimit,
+		memory:     make([]Entry, 0, capacity),
+	}
+}
+
+/*
+*
+assigns the next sequence number to the entry and records it
+*/
+func (j *Journal) Append(entry Entry) Entry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.sequence++
+	entry.Sequence = j.sequence
+	j.memory = append(j.memory, entry)
+	if len(j.memory) > j.Capacity {
+		j.spillEntry(j.memory[0])
+		j.memory[0] = Entry{}
+		j.memory = j.memory[1:]
+	}
+	return entry
+}
+
+func (j *Journal) Sequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.sequence
+}
+
+/*
+*
+returns every recorded entry after the given sequence. complete is false when
+part of the requested range is no longer available
+*/
+func (j *Journal) Since(sequence uint64) (entries []Entry, complete bool, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if sequence >= j.sequence {
+		return nil, true, nil
+	}
+	if len(j.memory) == 0 || j.memory[0].Sequence > sequence+1 {
+		entries, err = j.readSpill(sequence)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	for _, entry := range j.memory {
+		if entry.Sequence > sequence {
+			entries = append(entries, entry)
+		}
+	}
+	complete = len(entries) > 0 && entries[0].Sequence == sequence+1
+	return entries, complete, nil
+}
+
+func (j *Journal) spillEntry(entry Entry) {
+	if j.SpillPath == "" {
+		return
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	encoded = append(encoded, '\n')
+	if j.spill == nil || j.spilled+int64(len(encoded)) > j.SpillLimit {
+		err = j.resetSpill()
+		if err != nil {
+			return
+		}
+	}
+	n, err := j.spill.Write(encoded)
+	j.spilled += int64(n)
+}
+
+func (j *Journal) resetSpill() (err error) {
+	if j.spill != nil {
+		_ = j.spill.Close()
+		j.spill = nil
+	}
+	j.spilled = 0
+	j.spill, err = os.OpenFile(j.SpillPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	return err
+}
+
+func (j *Journal) readSpill(sequence uint64) (entries []Entry, err error) {
+	if j.spill == nil {
+		return nil, nil
+	}
+	file, err := os.Open(j.SpillPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if entry.Sequence > sequence {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
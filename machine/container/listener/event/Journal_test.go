@@ -0,0 +1,91 @@
+package event
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func journaled(j *Journal, count int) {
+	for i := 0; i < count; i++ {
+		j.Append(Entry{Type: Log, Container: "c1", Content: "line " + strconv.Itoa(i+1) + "\n"})
+	}
+}
+
+/*
+*
+fails unless the entries are exactly the sequences from first to last, in order
+*/
+func expectSequences(t *testing.T, entries []Entry, first uint64, last uint64) {
+	t.Helper()
+	if uint64(len(entries)) != last-first+1 {
+		t.Fatalf("expected sequences %d to %d, got %d entries", first, last, len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Sequence != first+uint64(i) {
+			t.Fatalf("expected sequence %d at %d, got %d", first+uint64(i), i, entry.Sequence)
+		}
+		if entry.Content != "line "+strconv.FormatUint(entry.Sequence, 10)+"\n" {
+			t.Fatalf("sequence %d came back with %q", entry.Sequence, entry.Content)
+		}
+	}
+}
+
+func TestJournalMemoryOnly(t *testing.T) {
+	j := NewJournal(3, "", 0)
+	journaled(j, 5)
+	if j.Sequence() != 5 {
+		t.Fatalf("expected sequence 5, got %d", j.Sequence())
+	}
+	entries, complete, err := j.Since(2)
+	if err != nil || !complete {
+		t.Fatalf("expected a complete replay from memory, got %v, %v", complete, err)
+	}
+	expectSequences(t, entries, 3, 5)
+	entries, complete, err = j.Since(0)
+	if err != nil || complete {
+		t.Fatalf("entries past the capacity are gone without a spill file, got %v, %v", complete, err)
+	}
+	expectSequences(t, entries, 3, 5)
+	entries, complete, err = j.Since(5)
+	if err != nil || !complete || len(entries) != 0 {
+		t.Fatalf("expected nothing to replay when up to date, got %d entries, %v, %v", len(entries), complete, err)
+	}
+}
+
+func TestJournalReplaysAcrossTheSpill(t *testing.T) {
+	j := NewJournal(2, filepath.Join(t.TempDir(), "events.journal"), 1<<20)
+	journaled(j, 6)
+	for since := uint64(0); since < 6; since++ {
+		entries, complete, err := j.Since(since)
+		if err != nil || !complete {
+			t.Fatalf("since %d: expected a complete replay, got %v, %v", since, complete, err)
+		}
+		expectSequences(t, entries, since+1, 6)
+	}
+}
+
+func TestJournalSpillStartsOverAtItsLimit(t *testing.T) {
+	// room for about two spilled entries, the third one truncates the file
+	j := NewJournal(2, filepath.Join(t.TempDir(), "events.journal"), 250)
+	journaled(j, 7)
+	entries, complete, err := j.Since(0)
+	if err != nil || complete {
+		t.Fatalf("expected an incomplete replay once the spill started over, got %v, %v", complete, err)
+	}
+	if len(entries) == 0 || entries[len(entries)-1].Sequence != 7 {
+		t.Fatalf("expected the replay to reach the latest entry, got %+v", entries)
+	}
+	expectSequences(t, entries, entries[0].Sequence, 7)
+	entries, complete, err = j.Since(4)
+	if err != nil || !complete {
+		t.Fatalf("expected the entries still spilled to replay completely, got %v, %v", complete, err)
+	}
+	expectSequences(t, entries, 5, 7)
+}
+
+func TestJournalEpochsDiffer(t *testing.T) {
+	if NewJournal(1, "", 0).Epoch == NewJournal(1, "", 0).Epoch {
+		t.Fatal("two journals share an epoch, a backend couldn't tell a restart apart")
+	}
+}
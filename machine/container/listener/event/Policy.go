@@ -0,0 +1,27 @@
+package event
+
+type Policy int
+
+const (
+	// Coalesce keeps a single pending entry per key, the newest one wins
+	Coalesce Policy = iota
+	// DropOldest keeps up to a limit of pending entries per container, discarding the oldest ones
+	DropOldest
+)
+
+var policies = map[Type]Policy{
+	Status:    Coalesce,
+	Progress:  Coalesce,
+	Load:      Coalesce,
+	Inventory: Coalesce,
+	Log:       DropOldest,
+	Alert:     DropOldest,
+}
+
+func (t Type) Policy() Policy {
+	policy, ok := policies[t]
+	if !ok {
+		return DropOldest
+	}
+	return policy
+}
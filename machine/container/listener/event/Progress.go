@@ -10,6 +10,8 @@ type ProgressUpdate struct {
 	Started     bool    `json:"started"`
 	Finished    bool    `json:"finished"`
 	Errored     bool    `json:"errored"`
+	Cancelled   bool    `json:"cancelled"`
+	Cancellable bool    `json:"cancellable"`
 	Progress    int     `json:"progress"`
 	Type        string  `json:"type"`
 	HeadSha     *string `json:"headSha"`
@@ -0,0 +1,175 @@
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+type queued struct {
+	entry   Entry
+	key     string
+	dropped bool
+}
+
+/*
+*
+bounded, non-blocking event queue between container handlers and the backend
+connection. producers never wait on the consumer, every type is handled
+according to its policy once the consumer falls behind
+*/
+type Queue struct {
+	Limit   int
+	mutex   sync.Mutex
+	ready   chan struct{}
+	order   []*queued
+	keyed   map[string]*queued
+	bounded map[string][]*queued
+	lost    map[string]int
+	dropped map[Type]int
+}
+
+func NewQueue(limit int) *Queue {
+	return &Queue{
+		Limit:   limit,
+		ready:   make(chan struct{}, 1),
+		keyed:   make(map[string]*queued),
+		bounded: make(map[string][]*queued),
+		lost:    make(map[string]int),
+		dropped: make(map[Type]int),
+	}
+}
+
+func (q *Queue) Push(entry Entry) {
+	q.mutex.Lock()
+	switch entry.Type.Policy() {
+	case Coalesce:
+		key := coalesceKey(entry)
+		if existing, ok := q.keyed[key]; ok {
+			existing.entry = entry
+			break
+		}
+		item := &queued{entry: entry, key: key}
+		q.keyed[key] = item
+		q.order = append(q.order, item)
+	default:
+		// bounded per type and container, a chatty container only evicts its own entries
+		bound := lostKey(entry)
+		pending := q.bounded[bound]
+		if len(pending) >= q.Limit {
+			oldest := pending[0]
+			oldest.dropped = true
+			pending = pending[1:]
+			q.lost[bound] += lines(oldest.entry) + oldest.entry.Dropped
+			q.dropped[entry.Type]++
+		}
+		item := &queued{entry: entry}
+		q.bounded[bound] = append(pending, item)
+		q.order = append(q.order, item)
+	}
+	q.mutex.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+/*
+*
+blocks until an entry is available or done is closed
+*/
+func (q *Queue) Pop(done <-chan struct{}) (entry Entry, ok bool) {
+	for {
+		q.mutex.Lock()
+		for len(q.order) > 0 {
+			item := q.order[0]
+			q.order[0] = nil
+			q.order = q.order[1:]
+			if item.dropped {
+				continue
+			}
+			entry = item.entry
+			if item.key != "" {
+				delete(q.keyed, item.key)
+			} else {
+				bound := lostKey(entry)
+				if pending := q.bounded[bound][1:]; len(pending) > 0 {
+					q.bounded[bound] = pending
+				} else {
+					delete(q.bounded, bound)
+				}
+				entry.Dropped += q.lost[bound]
+				delete(q.lost, bound)
+			}
+			q.mutex.Unlock()
+			return entry, true
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.ready:
+		case <-done:
+			return entry, false
+		}
+	}
+}
+
+/*
+*
+pending entries by type
+*/
+func (q *Queue) Depth() (depth map[Type]int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	depth = make(map[Type]int)
+	for _, item := range q.order {
+		if !item.dropped {
+			depth[item.entry.Type]++
+		}
+	}
+	return depth
+}
+
+/*
+*
+entries dropped since the queue was created, by type
+*/
+func (q *Queue) Dropped() (dropped map[Type]int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	dropped = make(map[Type]int, len(q.dropped))
+	for t, count := range q.dropped {
+		dropped[t] = count
+	}
+	return dropped
+}
+
+func coalesceKey(entry Entry) string {
+	key := string(entry.Type) + "/" + entry.Container + "/" + strings.Join(entry.Listeners, ",")
+	if entry.Type == Progress {
+		progress := ProgressUpdate{}
+		if json.Unmarshal([]byte(entry.Content), &progress) == nil {
+			key += "/" + progress.Id
+		}
+	}
+	return key
+}
+
+func lostKey(entry Entry) string {
+	return string(entry.Type) + "/" + entry.Container
+}
+
+/*
+*
+what an evicted entry counts for in the dropped counter: the lines of a log chunk
+(a trailing partial line included), one for any other entry
+*/
+func lines(entry Entry) int {
+	if entry.Type != Log {
+		return 1
+	}
+	count := strings.Count(entry.Content, "\n")
+	if entry.Content != "" && !strings.HasSuffix(entry.Content, "\n") {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,138 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+*
+pops everything pending without waiting for more
+*/
+func drain(q *Queue) (entries []Entry) {
+	done := make(chan struct{})
+	close(done)
+	for {
+		entry, ok := q.Pop(done)
+		if !ok {
+			return entries
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestQueueCoalesces(t *testing.T) {
+	q := NewQueue(2)
+	q.Push(Entry{Type: Status, Container: "c1", Listeners: []string{"*"}, Content: "starting"})
+	q.Push(Entry{Type: Status, Container: "c2", Listeners: []string{"*"}, Content: "running"})
+	q.Push(Entry{Type: Status, Container: "c1", Listeners: []string{"*"}, Content: "running"})
+	q.Push(Entry{Type: Progress, Container: "c1", Content: `{"id":"a","progress":10}`})
+	q.Push(Entry{Type: Progress, Container: "c1", Content: `{"id":"b","progress":10}`})
+	q.Push(Entry{Type: Progress, Container: "c1", Content: `{"id":"a","progress":50}`})
+	if depth := q.Depth(); depth[Status] != 2 || depth[Progress] != 2 {
+		t.Fatalf("expected one pending entry per key, got %v", depth)
+	}
+	entries := drain(q)
+	expected := []string{"running", "running", `{"id":"a","progress":50}`, `{"id":"b","progress":10}`}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Content != expected[i] {
+			t.Fatalf("entry %d: expected %q, got %q", i, expected[i], entry.Content)
+		}
+		if entry.Dropped != 0 {
+			t.Fatalf("coalesced entries aren't dropped, got %d on %+v", entry.Dropped, entry)
+		}
+	}
+	if dropped := q.Dropped(); len(dropped) != 0 {
+		t.Fatalf("coalescing counted as dropping: %v", dropped)
+	}
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	q := NewQueue(2)
+	q.Push(Entry{Type: Log, Container: "c1", Content: "one\ntwo\n"})
+	q.Push(Entry{Type: Log, Container: "c2", Content: "other\n"})
+	q.Push(Entry{Type: Log, Container: "c1", Content: "three\nfour"})
+	q.Push(Entry{Type: Log, Container: "c1", Content: "five\n"})
+	q.Push(Entry{Type: Log, Container: "c1", Content: "six\n"})
+	q.Push(Entry{Type: Alert, Container: "c1", Content: "first"})
+	q.Push(Entry{Type: Alert, Container: "c1", Content: "second"})
+	q.Push(Entry{Type: Alert, Container: "c1", Content: "third"})
+	entries := drain(q)
+	expected := []struct {
+		content string
+		dropped int
+	}{
+		// a chatty container only evicts its own entries
+		{"other\n", 0},
+		// two lines from the first chunk and two from the second, its partial last line included
+		{"five\n", 4},
+		{"six\n", 0},
+		{"second", 1},
+		{"third", 0},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Content != expected[i].content || entry.Dropped != expected[i].dropped {
+			t.Fatalf("entry %d: expected %q dropping %d, got %q dropping %d", i, expected[i].content, expected[i].dropped, entry.Content, entry.Dropped)
+		}
+	}
+	if dropped := q.Dropped(); dropped[Log] != 2 || dropped[Alert] != 1 {
+		t.Fatalf("expected 2 log and 1 alert entries dropped, got %v", dropped)
+	}
+}
+
+func TestQueueCarriesDropsAcrossEvictions(t *testing.T) {
+	q := NewQueue(1)
+	q.Push(Entry{Type: Log, Container: "c1", Content: "one\n"})
+	q.Push(Entry{Type: Log, Container: "c1", Content: "two\n"})
+	q.Push(Entry{Type: Log, Container: "c1", Content: "three\n"})
+	entries := drain(q)
+	if len(entries) != 1 || entries[0].Content != "three\n" || entries[0].Dropped != 2 {
+		t.Fatalf("expected the last chunk to report both lines dropped before it, got %+v", entries)
+	}
+	// the counter starts over once an entry has reported the loss
+	q.Push(Entry{Type: Log, Container: "c1", Content: "four\n"})
+	entries = drain(q)
+	if len(entries) != 1 || entries[0].Dropped != 0 {
+		t.Fatalf("expected no drops after the loss was reported, got %+v", entries)
+	}
+	if depth := q.Depth(); len(depth) != 0 {
+		t.Fatalf("expected an empty queue, got %v", depth)
+	}
+}
+
+func TestQueuePopWaitsForEntries(t *testing.T) {
+	q := NewQueue(1)
+	popped := make(chan Entry)
+	go func() {
+		entry, _ := q.Pop(make(chan struct{}))
+		popped <- entry
+	}()
+	select {
+	case entry := <-popped:
+		t.Fatalf("pop returned %+v from an empty queue", entry)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.Push(Entry{Type: Status, Container: "c1", Content: "running"})
+	select {
+	case entry := <-popped:
+		if entry.Content != "running" {
+			t.Fatalf("expected the pushed entry, got %+v", entry)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop didn't wake up on push")
+	}
+	done := make(chan struct{})
+	go func() {
+		close(done)
+	}()
+	_, ok := q.Pop(done)
+	if ok {
+		t.Fatal("expected pop to give up once done is closed")
+	}
+}
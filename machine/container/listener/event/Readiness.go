@@ -0,0 +1,9 @@
+package event
+
+type ReadinessState string
+
+const (
+	Starting       ReadinessState = "starting"
+	Ready          ReadinessState = "ready"
+	StartupTimeout ReadinessState = "startup_timeout"
+)
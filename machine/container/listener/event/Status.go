@@ -10,6 +10,8 @@ type StatusUpdate struct {
 	Restarting bool   `json:"restarting"`
 	Paused     bool   `json:"paused"`
 	Status     string `json:"status"`
+	// log based readiness, only present while running
+	Readiness ReadinessState `json:"readiness,omitempty"`
 }
 
 func (u *StatusUpdate) FromContainerState(state *types.ContainerState) *StatusUpdate {
@@ -7,4 +7,7 @@ const (
 	Status        = "status"
 	Progress      = "progress"
 	Load          = "load"
+	Alert         = "alert"
+	// machine wide, sent when the periodic refresh finds the inventory changed
+	Inventory = "inventory"
 )
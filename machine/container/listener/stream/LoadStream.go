@@ -5,6 +5,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"io"
 	"supervisor/machine/container/listener/event"
+	"supervisor/machine/metrics"
 	"time"
 )
 
@@ -13,6 +14,7 @@ func (s *Stream) StreamLoad() (err error) {
 	s.Mutex.Lock()
 	err = s.PreCheck()
 	if err != nil {
+		s.Mutex.Unlock()
 		return err
 	}
 	load, err := s.Client.ContainerStats(s.Ctx, s.ContainerName, true)
@@ -36,6 +38,7 @@ func (s *Stream) StreamLoad() (err error) {
 		s.Cancel = nil
 		s.Ctx = nil
 		s.Mutex.Unlock()
+		metrics.Forget(s.ContainerId)
 		s.logger().Info("load stream ended")
 	}()
 
@@ -53,6 +56,7 @@ func (s *Stream) StreamLoad() (err error) {
 					s.logger().Warn("error while unmarshalling stats")
 					s.logger().Warn(parseErr)
 				} else if stat.CPUStats.OnlineCPUs != 0 {
+					metrics.ObserveLoad(s.ContainerId, cpuPercent(stat), memoryUsage(stat), stat.MemoryStats.Limit)
 					*s.HandlerEvents <- event.Entry{
 						Type:    event.Load,
 						Content: string(buffer[:n]),
@@ -68,3 +72,25 @@ func (s *Stream) StreamLoad() (err error) {
 		}
 	}
 }
+
+// same as docker stats, 100 per fully used core
+func cpuPercent(stat types.Stats) float64 {
+	cpuDelta := float64(stat.CPUStats.CPUUsage.TotalUsage) - float64(stat.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stat.CPUStats.SystemUsage) - float64(stat.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * float64(stat.CPUStats.OnlineCPUs) * 100
+}
+
+// page cache is reclaimable, docker stats leaves it out as well
+func memoryUsage(stat types.Stats) uint64 {
+	cache := stat.MemoryStats.Stats["inactive_file"]
+	if cache == 0 {
+		cache = stat.MemoryStats.Stats["total_inactive_file"]
+	}
+	if cache > stat.MemoryStats.Usage {
+		return 0
+	}
+	return stat.MemoryStats.Usage - cache
+}
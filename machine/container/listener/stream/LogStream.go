@@ -13,6 +13,7 @@ func (s *Stream) StreamLogs() (err error) {
 	s.Mutex.Lock()
 	err = s.PreCheck()
 	if err != nil {
+		s.Mutex.Unlock()
 		return err
 	}
 	logs, err := s.Client.ContainerLogs(s.Ctx, s.ContainerName, container.LogsOptions{
@@ -0,0 +1,17 @@
+package stream
+
+import "time"
+
+type State struct {
+	Open     bool      `json:"open"`
+	LastRead time.Time `json:"lastRead"`
+}
+
+func (s *Stream) State() State {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return State{
+		Open:     s.Open,
+		LastRead: s.LastRead,
+	}
+}
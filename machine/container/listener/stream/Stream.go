@@ -30,9 +30,9 @@ type Stream struct {
 
 func (s *Stream) logger() (logger *log.Entry) {
 	return log.WithFields(log.Fields{
-		"handler": s.ContainerId,
-		"open":    s.Open,
-		"type":    s.Type,
+		"container": s.ContainerId,
+		"open":      s.Open,
+		"type":      s.Type,
 	})
 }
 
@@ -65,6 +65,27 @@ func (s *Stream) PreCheck() (err error) {
 	return err
 }
 
+/*
+*
+opens the stream in the background unless it is already open. repeated status events
+and subscriptions don't pile up goroutines waiting on the lock
+*/
+func (s *Stream) Follow() {
+	s.Mutex.Lock()
+	open := s.Open
+	s.Mutex.Unlock()
+	if open {
+		return
+	}
+	go func() {
+		if s.Type == event.Load {
+			_ = s.StreamLoad()
+		} else {
+			_ = s.StreamLogs()
+		}
+	}()
+}
+
 func (s *Stream) Close() {
 	if s.Cancel != nil {
 		s.Cancel()
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"sync/atomic"
+	"time"
+)
+
+/*
+*
+supervisor collectors. packages record into them directly, the machine registers
+the ones that read its own state and serves the registry
+*/
+var Registry = prometheus.NewRegistry()
+
+var (
+	Commands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "serverbench",
+		Name:      "commands_total",
+		Help:      "Commands handled, by realm, command and result (ok or the error code).",
+	}, []string{"realm", "command", "result"})
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "serverbench",
+		Name:      "command_duration_seconds",
+		Help:      "Time spent running a command, queueing excluded.",
+		Buckets:   []float64{.005, .025, .1, .5, 1, 5, 15, 60, 300, 900},
+	}, []string{"realm", "command"})
+	Activities = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "serverbench",
+		Name:      "activities_total",
+		Help:      "Finished activities, by type and outcome (succeeded, failed, cancelled).",
+	}, []string{"type", "outcome"})
+	ActivityDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "serverbench",
+		Name:      "activity_duration_seconds",
+		Help:      "Duration of finished activities, by type and outcome.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"type", "outcome"})
+	ContainerCpu = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "serverbench",
+		Name:      "container_cpu_percent",
+		Help:      "Container cpu usage from the load stream, 100 per fully used core.",
+	}, []string{"container"})
+	ContainerMemory = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "serverbench",
+		Name:      "container_memory_bytes",
+		Help:      "Container memory usage from the load stream, page cache excluded.",
+	}, []string{"container"})
+	ContainerMemoryLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "serverbench",
+		Name:      "container_memory_limit_bytes",
+		Help:      "Container memory limit from the load stream.",
+	}, []string{"container"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Commands,
+		CommandDuration,
+		Activities,
+		ActivityDuration,
+		ContainerCpu,
+		ContainerMemory,
+		ContainerMemoryLimit,
+	)
+}
+
+// set once the registry is served, load is then streamed for every running container
+var enabled atomic.Bool
+
+func Enable() {
+	enabled.Store(true)
+}
+
+func Enabled() bool {
+	return enabled.Load()
+}
+
+func ObserveCommand(realm string, command string, result string, duration time.Duration) {
+	Commands.WithLabelValues(realm, command, result).Inc()
+	CommandDuration.WithLabelValues(realm, command).Observe(duration.Seconds())
+}
+
+func ObserveActivity(activityType string, outcome string, duration time.Duration) {
+	Activities.WithLabelValues(activityType, outcome).Inc()
+	ActivityDuration.WithLabelValues(activityType, outcome).Observe(duration.Seconds())
+}
+
+func ObserveLoad(container string, cpu float64, memory uint64, limit uint64) {
+	ContainerCpu.WithLabelValues(container).Set(cpu)
+	ContainerMemory.WithLabelValues(container).Set(float64(memory))
+	ContainerMemoryLimit.WithLabelValues(container).Set(float64(limit))
+}
+
+/*
+*
+drops the load series of a container that is no longer hosted
+*/
+func Forget(container string) {
+	ContainerCpu.DeleteLabelValues(container)
+	ContainerMemory.DeleteLabelValues(container)
+	ContainerMemoryLimit.DeleteLabelValues(container)
+}
@@ -0,0 +1,5 @@
+package in
+
+type ActivityRequest struct {
+	Id string `json:"id"`
+}
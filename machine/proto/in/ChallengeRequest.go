@@ -0,0 +1,16 @@
+package in
+
+type ChallengeRequest struct {
+	// nonce the supervisor has to prove the token against
+	Nonce string `json:"nonce"`
+	// backend proof of the token over the supervisor nonce
+	Proof string `json:"proof"`
+	// every following message will be signed
+	Signed bool `json:"signed"`
+	// negotiated protocol version and capabilities, v1 when missing
+	Protocol     int      `json:"protocol"`
+	Capabilities []string `json:"capabilities"`
+	// last event the backend received, missing when it has none. the gap is replayed
+	// before any new event is sent
+	Replay *ReplayRequest `json:"replay"`
+}
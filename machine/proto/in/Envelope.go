@@ -0,0 +1,36 @@
+package in
+
+import "encoding/json"
+
+/*
+*
+protocol v2 message, data travels as a typed json payload instead of an encoded
+string
+*/
+type Envelope struct {
+	Version   int             `json:"v"`
+	Rid       string          `json:"rid"`
+	Realm     string          `json:"realm"`
+	Command   string          `json:"command"`
+	Target    *string         `json:"target,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+func (e *Envelope) Message() (message Message) {
+	message = Message{
+		Rid:       e.Rid,
+		Realm:     e.Realm,
+		Command:   e.Command,
+		Target:    e.Target,
+		Timestamp: e.Timestamp,
+		Signature: e.Signature,
+	}
+	if len(e.Data) > 0 && string(e.Data) != "null" {
+		data := string(e.Data)
+		message.Data = &data
+		message.SignedData = []byte(e.Data)
+	}
+	return message
+}
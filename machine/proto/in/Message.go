@@ -1,9 +1,30 @@
 package in
 
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	MissingDataErr = errors.New("the command requires data")
+)
+
 type Message struct {
 	Rid     string  `json:"rid"`
 	Realm   string  `json:"realm"`
 	Command string  `json:"command"`
 	Target  *string `json:"target"`
 	Data    *string `json:"data"`
+	// only present on signed sessions
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// data exactly as it was on the wire when it wasn't a plain string, see canonical
+	SignedData []byte `json:"-"`
+}
+
+func (m *Message) Decode(target interface{}) (err error) {
+	if m.Data == nil {
+		return MissingDataErr
+	}
+	return json.Unmarshal([]byte(*m.Data), target)
 }
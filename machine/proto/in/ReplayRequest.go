@@ -0,0 +1,6 @@
+package in
+
+type ReplayRequest struct {
+	Epoch    string `json:"epoch"`
+	Sequence uint64 `json:"sequence"`
+}
@@ -0,0 +1,5 @@
+package in
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
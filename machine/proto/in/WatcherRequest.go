@@ -0,0 +1,5 @@
+package in
+
+type WatcherRequest struct {
+	Id string `json:"id"`
+}
@@ -0,0 +1,5 @@
+package out
+
+type ChallengeResponse struct {
+	Proof string `json:"proof"`
+}
@@ -0,0 +1,8 @@
+package out
+
+import "supervisor/machine/container/listener"
+
+type ContainerState struct {
+	Id      string          `json:"id"`
+	Handler *listener.State `json:"handler,omitempty"`
+}
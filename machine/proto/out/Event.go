@@ -0,0 +1,41 @@
+package out
+
+import (
+	"encoding/json"
+	"supervisor/machine/container/listener/event"
+	"time"
+)
+
+/*
+*
+protocol v2 event, the content is embedded as json instead of an encoded string
+*/
+type Event struct {
+	Version   int             `json:"v"`
+	Sequence  uint64          `json:"sequence"`
+	Timestamp time.Time       `json:"timestamp"`
+	Type      event.Type      `json:"type"`
+	Container string          `json:"container"`
+	Listeners []string        `json:"listeners"`
+	Dropped   int             `json:"dropped,omitempty"`
+	Content   json.RawMessage `json:"content"`
+}
+
+func EventFromEntry(entry event.Entry, version int) (e Event) {
+	e = Event{
+		Version:   version,
+		Sequence:  entry.Sequence,
+		Timestamp: entry.Timestamp,
+		Type:      entry.Type,
+		Container: entry.Container,
+		Listeners: entry.Listeners,
+		Dropped:   entry.Dropped,
+	}
+	if entry.Type != event.Log && json.Valid([]byte(entry.Content)) {
+		e.Content = json.RawMessage(entry.Content)
+	} else {
+		// raw log output (or anything that isn't json) is sent as a json string
+		e.Content, _ = json.Marshal(entry.Content)
+	}
+	return e
+}
@@ -0,0 +1,39 @@
+package out
+
+import "time"
+
+type InventoryResponse struct {
+	Hostname   string            `json:"hostname"`
+	Cpu        CpuInventory      `json:"cpu"`
+	Memory     MemoryInventory   `json:"memory"`
+	Disks      []DiskInventory   `json:"disks"`
+	Kernel     string            `json:"kernel"`
+	Os         string            `json:"os"`
+	Docker     string            `json:"docker,omitempty"`
+	Supervisor string            `json:"supervisor"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// when the inventory was collected
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+type CpuInventory struct {
+	Model string `json:"model"`
+	Cores int    `json:"cores"`
+}
+
+// bytes
+type MemoryInventory struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+/*
+*
+a filesystem holding container data, reported once however many containers it holds
+*/
+type DiskInventory struct {
+	Path       string   `json:"path"`
+	Containers []string `json:"containers,omitempty"`
+	Total      uint64   `json:"total"`     // bytes
+	Available  uint64   `json:"available"` // bytes
+}
@@ -0,0 +1,8 @@
+package out
+
+type ReplayResponse struct {
+	Epoch    string `json:"epoch"`
+	Replayed int    `json:"replayed"`
+	Sequence uint64 `json:"sequence"`
+	Complete bool   `json:"complete"`
+}
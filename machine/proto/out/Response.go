@@ -1,8 +1,17 @@
 package out
 
+import "time"
+
 type Response struct {
-	Rid   string      `json:"rid"`
-	Type  string      `json:"type"`
-	Data  interface{} `json:"data"`
-	Error bool        `json:"error"`
+	// protocol v2 only
+	Version   int         `json:"v,omitempty"`
+	Timestamp *time.Time  `json:"timestamp,omitempty"`
+	Rid       string      `json:"rid"`
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	// acks only, queued or started
+	State string `json:"state,omitempty"`
+	Error bool   `json:"error"`
+	// present when error is set
+	Failure *ErrorResponse `json:"failure,omitempty"`
 }
@@ -0,0 +1,16 @@
+package out
+
+import "time"
+
+type StatusResponse struct {
+	Connected    bool           `json:"connected"`
+	ConnectedAt  time.Time      `json:"connectedAt"`
+	LastPong     time.Time      `json:"lastPong"`
+	RoundTrip    int64          `json:"roundTrip"` // milliseconds
+	Reconnects   int            `json:"reconnects"`
+	Containers   int            `json:"containers"`
+	Queue        map[string]int `json:"queue"`
+	Sequence     uint64         `json:"sequence"`
+	Protocol     int            `json:"protocol"`
+	Capabilities []string       `json:"capabilities"`
+}
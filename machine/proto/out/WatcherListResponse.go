@@ -0,0 +1,7 @@
+package out
+
+import "supervisor/machine/container/listener"
+
+type WatcherListResponse struct {
+	Watchers []listener.Watcher `json:"watchers"`
+}
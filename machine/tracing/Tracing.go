@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"supervisor/machine/redact"
+)
+
+const (
+	serviceName = "serverbench-supervisor"
+	scope       = "supervisor"
+)
+
+var (
+	ConflictingExportersErr = errors.New("traces go either to an otlp endpoint or to a file, not both")
+)
+
+// binaries whose arguments may carry secrets the redaction can't recognise
+var opaqueArguments = map[string]bool{"sh": true, "bash": true, "sshpass": true}
+
+/*
+*
+installs the global tracer provider. spans are exported over otlp/http to the endpoint,
+or appended as json lines to the file. without either, tracing stays a no-op
+*/
+func Configure(endpoint string, file string) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if endpoint != "" && file != "" {
+		return noop, ConflictingExportersErr
+	}
+	var exporter sdktrace.SpanExporter
+	switch {
+	case endpoint != "":
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	case file != "":
+		var output *os.File
+		output, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(output))
+		}
+	default:
+		return noop, nil
+	}
+	if err != nil {
+		return noop, err
+	}
+	hostname, _ := os.Hostname()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.HostName(hostname),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(scope).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+/*
+*
+records the error on the span, if any, and ends it
+*/
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(errors.New(redact.String(err.Error())))
+		span.SetStatus(codes.Error, redact.String(err.Error()))
+	}
+	span.End()
+}
+
+/*
+*
+a child span for a host command, named after the binary. arguments are attached
+redacted, and left out entirely for shells and sshpass
+*/
+func command(ctx context.Context, cmd *exec.Cmd) trace.Span {
+	binary := filepath.Base(cmd.Path)
+	attributes := []attribute.KeyValue{attribute.String("process.executable.name", binary)}
+	if !opaqueArguments[binary] && len(cmd.Args) > 1 {
+		attributes = append(attributes, attribute.String("process.command_args", redact.String(strings.Join(cmd.Args[1:], " "))))
+	}
+	_, span := Start(ctx, "exec "+binary, attributes...)
+	return span
+}
+
+func Run(ctx context.Context, cmd *exec.Cmd) (err error) {
+	span := command(ctx, cmd)
+	err = cmd.Run()
+	End(span, err)
+	return err
+}
+
+func Output(ctx context.Context, cmd *exec.Cmd) (output []byte, err error) {
+	span := command(ctx, cmd)
+	output, err = cmd.Output()
+	End(span, err)
+	return output, err
+}